@@ -0,0 +1,305 @@
+package corpustools
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The ARPA format lists the ngrams of a backoff language model by order, each with the base 10 logarithm of its
+// probability and, below the highest order, the base 10 logarithm of its backoff weight:
+//
+//	\data\
+//	ngram 1=3
+//	ngram 2=2
+//
+//	\1-grams:
+//	-0.4771213	the	-0.30103
+//	...
+//	\2-grams:
+//	-0.1760913	the cat
+//	...
+//	\end\
+//
+// The probability of a word following a context is that of the longest listed ngram made of the word and a suffix of
+// the context, multiplied by the backoff weights of the longer suffixes of the context which are listed. A Kneser-Ney
+// model is written with all of its ngrams (or those occurring often enough), and an ARPA file can be read into a model
+// which needs no corpus.
+
+//
+// Writing.
+//
+
+// Writes the model in ARPA format. Every token of the vocabulary is listed as a unigram, and min_frequencies[n-1], if
+// given, is the minimum frequency in the corpus of the ngrams of order n > 1 which are listed. As an ngram can only be
+// listed if its prefix is, the minimum frequency of each order is raised to those of the lower orders. The backoff
+// weights are computed so that the probabilities of the listed model sum to one.
+//
+// Tokens which cannot be written, because they are empty or contain whitespace, are counted together as the unigram
+// <unk>, and the ngrams containing them are not listed. The unigrams <unk>, <s> and </s> are always listed, so that the
+// model can be read by toolkits which expect them, with a log probability of -99 if they have no probability.
+func (lm *KneserNey) WriteARPA(w io.Writer, min_frequencies []int) error {
+	voc := lm.corpus.voc
+	// Collect the listed ngrams of each order, with their probabilities.
+	entries := make([][]arpaEntry, lm.order+1)
+	index := make([]map[string]int, lm.order+1) // The position of each ngram in its list of entries.
+	unwritable, unknown_prob := make([]bool, voc.Size()), 0.0
+	for type_int := 0; type_int < voc.Size(); type_int++ {
+		if str := voc.String(type_int); str == "" || strings.IndexFunc(str, unicode.IsSpace) >= 0 {
+			unwritable[type_int] = true
+			unknown_prob += lm.Prob(nil, type_int)
+			continue
+		}
+		entries[1] = append(entries[1], arpaEntry{ngram: []int{type_int}, prob: lm.Prob(nil, type_int)})
+	}
+	// The unigrams which are not in the vocabulary are given negative integers.
+	extra := make(map[int]string)
+	for _, str := range []string{UnknownToken, "<s>", "</s>"} {
+		if _, found := voc.ID(str); !found {
+			extra[-1-len(extra)] = str
+			entries[1] = append(entries[1], arpaEntry{ngram: []int{-len(extra)}})
+		}
+	}
+	index[1] = make(map[string]int, len(entries[1]))
+	for i, entry := range entries[1] {
+		index[1][seqKey(entry.ngram)] = i
+		if extra[entry.ngram[0]] == UnknownToken || voc.String(entry.ngram[0]) == UnknownToken {
+			entries[1][i].prob += unknown_prob
+		}
+	}
+	// The sums of the probabilities of the listed words following each context, given the context and its suffix.
+	sums := make([][][2]float64, lm.order+1)
+	sums[1] = make([][2]float64, len(entries[1]))
+	min_frequency := 1
+	for n := 2; n <= lm.order; n++ {
+		if index[n-1] == nil {
+			index[n-1] = make(map[string]int, len(entries[n-1]))
+			for i, entry := range entries[n-1] {
+				index[n-1][seqKey(entry.ngram)] = i
+			}
+		}
+		if n-1 < len(min_frequencies) && min_frequencies[n-1] > min_frequency {
+			min_frequency = min_frequencies[n-1]
+		}
+		lm.corpus.EachNgram(NgramOptions{MinOrder: n, MinFrequency: min_frequency}, func(ngram []int, frequency, slo, shi int) bool {
+			for _, type_int := range ngram {
+				if unwritable[type_int] {
+					return true
+				}
+			}
+			entry := arpaEntry{ngram: append([]int(nil), ngram...), prob: lm.Prob(ngram[:n-1], ngram[n-1])}
+			i := index[n-1][seqKey(ngram[:n-1])]
+			sums[n-1][i][0] += entry.prob
+			if n == 2 {
+				// The unigram probability of <unk> includes the tokens which cannot be written.
+				sums[n-1][i][1] += entries[1][index[1][seqKey(ngram[1:])]].prob
+			} else {
+				sums[n-1][i][1] += lm.Prob(ngram[1:n-1], ngram[n-1])
+			}
+			entries[n] = append(entries[n], entry)
+			return true
+		})
+		sums[n] = make([][2]float64, len(entries[n]))
+	}
+	for n := 1; n < lm.order; n++ {
+		for i := range entries[n] {
+			if sum := sums[n][i]; sum[0] > 0.0 && sum[0] < 1.0 && sum[1] < 1.0 {
+				entries[n][i].backoff = math.Log10((1.0 - sum[0]) / (1.0 - sum[1]))
+			}
+		}
+	}
+	// Write the model.
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "\\data\\\n")
+	for n := 1; n <= lm.order; n++ {
+		fmt.Fprintf(bw, "ngram %d=%d\n", n, len(entries[n]))
+	}
+	for n := 1; n <= lm.order; n++ {
+		fmt.Fprintf(bw, "\n\\%d-grams:\n", n)
+		for _, entry := range entries[n] {
+			log_prob, words := math.Log10(entry.prob), strings.Join(lm.corpus.ToString(entry.ngram), " ")
+			if str, found := extra[entry.ngram[0]]; found {
+				words = str
+			}
+			if entry.prob == 0.0 {
+				log_prob = -99.0
+			}
+			fmt.Fprintf(bw, "%.7g\t%s", log_prob, words)
+			if n < lm.order {
+				fmt.Fprintf(bw, "\t%.7g", entry.backoff)
+			}
+			bw.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(bw, "\n\\end\\\n")
+	return bw.Flush()
+}
+
+// Writes the model to a file in ARPA format (see WriteARPA).
+func (lm *KneserNey) WriteARPAFile(filename string, min_frequencies []int) (err error) {
+	fh, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = lm.WriteARPA(fh, min_frequencies); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// A listed ngram, with its probability given its prefix and the base 10 logarithm of its backoff weight.
+type arpaEntry struct {
+	ngram   []int
+	prob    float64
+	backoff float64
+}
+
+//
+// Reading.
+//
+
+// A backoff language model read from an ARPA file.
+type ARPAModel struct {
+	voc    *Vocabulary
+	order  int
+	ngrams []map[string]arpaValue // The listed ngrams of each order, keyed by seqKey.
+}
+
+// The base 10 logarithms of the probability and backoff weight of a listed ngram.
+type arpaValue struct {
+	prob    float64
+	backoff float64
+}
+
+// Returns a language model read from an ARPA file, which may be compressed. Malformed lines are reported with a
+// FormatError.
+func ReadARPA(r io.Reader) (lm *ARPAModel, err error) {
+	if r, err = decompress(r, "<reader>"); err != nil {
+		return nil, err
+	}
+	return readARPA(r, "<reader>")
+}
+
+// Returns a language model read from an ARPA file, which may be compressed (see compress.go).
+func ReadARPAFile(filename string) (lm *ARPAModel, err error) {
+	fh, err := openInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return readARPA(fh, filename)
+}
+
+func readARPA(r io.Reader, name string) (lm *ARPAModel, err error) {
+	lm = &ARPAModel{voc: NewVocabulary(), ngrams: []map[string]arpaValue{nil}}
+	counts := []int{0} // The number of ngrams of each order given in the header.
+	section := -1      // The order of the ngrams being read, 0 in the header, or -1 before it.
+	ended := false
+	err = eachNumberedLine(r, name, func(line string, line_num int) error {
+		line = strings.TrimSpace(line)
+		malformed := func(reason string) error {
+			return &FormatError{Filename: name, Line: line_num, Reason: reason}
+		}
+		switch {
+		case line == "" || ended:
+			return nil
+		case line == "\\data\\":
+			section = 0
+		case line == "\\end\\":
+			ended = true
+		case section == -1:
+			// Skip any text before the header.
+		case strings.HasPrefix(line, "\\") && strings.HasSuffix(line, "-grams:"):
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "\\"), "-grams:"))
+			if err != nil || n != section+1 || n >= len(counts) {
+				return malformed(fmt.Sprintf("unexpected section %s", line))
+			}
+			section = n
+			lm.ngrams = append(lm.ngrams, make(map[string]arpaValue, counts[n]))
+		case section == 0:
+			n, count, found := strings.Cut(strings.TrimPrefix(line, "ngram "), "=")
+			order, err1 := strconv.Atoi(strings.TrimSpace(n))
+			number, err2 := strconv.Atoi(strings.TrimSpace(count))
+			if !found || !strings.HasPrefix(line, "ngram ") || err1 != nil || err2 != nil || order != len(counts) || number < 0 {
+				return malformed(fmt.Sprintf("malformed ngram count %q", line))
+			}
+			counts = append(counts, number)
+		default:
+			fields := strings.Fields(line)
+			if len(fields) != section+1 && len(fields) != section+2 {
+				return malformed(fmt.Sprintf("expected %d or %d fields, found %d", section+1, section+2, len(fields)))
+			}
+			var value arpaValue
+			var err error
+			if value.prob, err = strconv.ParseFloat(fields[0], 64); err != nil {
+				return malformed(fmt.Sprintf("malformed probability %q", fields[0]))
+			}
+			if len(fields) == section+2 {
+				if value.backoff, err = strconv.ParseFloat(fields[section+1], 64); err != nil {
+					return malformed(fmt.Sprintf("malformed backoff weight %q", fields[section+1]))
+				}
+			}
+			ngram := make([]int, section)
+			for i, token := range fields[1 : section+1] {
+				type_int, found := lm.voc.ID(token)
+				if !found {
+					if section > 1 {
+						return malformed(fmt.Sprintf("token %q is not a unigram", token))
+					}
+					type_int = lm.voc.add(token)
+				}
+				ngram[i] = type_int
+			}
+			lm.ngrams[section][seqKey(ngram)] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ended || len(counts) < 2 {
+		return nil, &FormatError{Filename: name, Line: 0, Reason: "missing \\data\\ header or \\end\\"}
+	}
+	lm.order = len(counts) - 1
+	for n := 1; n <= lm.order; n++ {
+		if n >= len(lm.ngrams) || len(lm.ngrams[n]) != counts[n] {
+			return nil, &FormatError{Filename: name, Line: 0, Reason: fmt.Sprintf("the number of %d-grams does not match the header", n)}
+		}
+	}
+	return lm, nil
+}
+
+// Returns the order of the model.
+func (lm *ARPAModel) Order() int {
+	return lm.order
+}
+
+func (lm *ARPAModel) Vocabulary() *Vocabulary {
+	return lm.voc.view()
+}
+
+func (lm *ARPAModel) LogProb(context []int, word int) float64 {
+	context = lastTokens(context, lm.order-1)
+	backoff := 0.0
+	for k := len(context); k >= 0; k-- {
+		suffix := context[len(context)-k:]
+		// Keys are concatenated varints, so joining two keys gives the key of the joined sequences.
+		if value, found := lm.ngrams[k+1][seqKey(suffix)+seqKey([]int{word})]; found {
+			return (value.prob + backoff) / math.Log10(2.0)
+		}
+		if k > 0 {
+			backoff += lm.ngrams[k][seqKey(suffix)].backoff
+		}
+	}
+	return math.Inf(-1)
+}
+
+func (lm *ARPAModel) Score(seq []int) float64 {
+	return scoreSequence(lm, seq)
+}
@@ -0,0 +1,129 @@
+package corpustools
+
+import (
+	"math"
+	"math/bits"
+)
+
+// A read-only array of non-negative integers. The corpus and its suffix array are stored as intArrays so that the
+// narrowest layout able to hold their values can be used: 32 bits per element when the values fit, bit-packed elements
+// of a given width, or 64 bits per element otherwise.
+type intArray interface {
+	Len() int
+	At(i int) int
+}
+
+// Returns an array holding a copy of values, each of which must lie in [0, max], in the narrowest byte-aligned layout.
+func newIntArray(values []int, max int) intArray {
+	if max < 0 || uint64(max) <= math.MaxUint32 {
+		arr := make(narrowArray, len(values))
+		for i, v := range values {
+			arr[i] = uint32(v)
+		}
+		return arr
+	}
+	arr := make(wideArray, len(values))
+	copy(arr, values)
+	return arr
+}
+
+// Returns the number of bits needed to store values in [0, max].
+func bitsFor(max int) uint {
+	if max <= 0 {
+		return 1
+	}
+	return uint(bits.Len(uint(max)))
+}
+
+// Returns a copy of the elements of an array as a slice.
+func intsOf(arr intArray) (values []int) {
+	values = make([]int, arr.Len())
+	for i := range values {
+		values[i] = arr.At(i)
+	}
+	return
+}
+
+// Returns a copy of the elements of an array in [lo, hi).
+func sliceOf(arr intArray, lo, hi int) (values []int) {
+	values = make([]int, hi-lo)
+	for i := range values {
+		values[i] = arr.At(lo + i)
+	}
+	return
+}
+
+//
+// Array layouts.
+//
+
+// 64 bits per element.
+type wideArray []int
+
+func (arr wideArray) Len() int {
+	return len(arr)
+}
+
+func (arr wideArray) At(i int) int {
+	return arr[i]
+}
+
+// 32 bits per element.
+type narrowArray []uint32
+
+func (arr narrowArray) Len() int {
+	return len(arr)
+}
+
+func (arr narrowArray) At(i int) int {
+	return int(arr[i])
+}
+
+// A fixed number of bits per element, packed into 64-bit words with element i occupying bits [i*width, (i+1)*width) and
+// bit 0 being the least significant bit of the first word.
+type packedArray struct {
+	words []uint64
+	width uint
+	n     int
+	mask  uint64
+}
+
+// Returns a copy of an array with each element packed into the given number of bits.
+func newPackedArray(arr intArray, width uint) *packedArray {
+	packed := &packedArray{words: make([]uint64, packedWords(arr.Len(), width)), width: width, n: arr.Len(), mask: (1 << width) - 1}
+	if width == 64 {
+		packed.mask = math.MaxUint64
+	}
+	for i := 0; i < arr.Len(); i++ {
+		bit := uint(i) * width
+		w, off := bit/64, bit%64
+		v := uint64(arr.At(i))
+		packed.words[w] |= v << off
+		if off+width > 64 {
+			packed.words[w+1] |= v >> (64 - off)
+		}
+	}
+	return packed
+}
+
+// Returns the number of 64-bit words needed to pack n elements of the given width.
+func packedWords(n int, width uint) int {
+	return int((uint64(n)*uint64(width) + 63) / 64)
+}
+
+func (arr *packedArray) Len() int {
+	return arr.n
+}
+
+func (arr *packedArray) At(i int) int {
+	if i < 0 || i >= arr.n {
+		panic("corpustools: packed array index out of range")
+	}
+	bit := uint(i) * arr.width
+	w, off := bit/64, bit%64
+	v := arr.words[w] >> off
+	if off+arr.width > 64 {
+		v |= arr.words[w+1] << (64 - off)
+	}
+	return int(v & arr.mask)
+}
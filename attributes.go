@@ -0,0 +1,79 @@
+package corpustools
+
+import (
+	"fmt"
+)
+
+// Besides its word forms, each token of a corpus may carry annotations such as its lemma or part of speech. Each kind of
+// annotation is an attribute layer aligned with the corpus sequence, with its own vocabulary. The word forms are the
+// "word" attribute, and are the only layer indexed by the suffix array; the other layers are searched by scanning or by
+// filtering the occurrences found through the word forms (see cql.go).
+
+// The name of the attribute holding the word forms of the corpus.
+const WordAttribute = "word"
+
+// An annotation layer of the corpus.
+type attribute struct {
+	name string
+	voc  *Vocabulary
+	seq  intArray
+}
+
+//
+// Attribute layers.
+//
+
+// Returns the names of the attributes of the corpus, starting with WordAttribute.
+func (corpus *Corpus) Attributes() (names []string) {
+	names = []string{WordAttribute}
+	for _, attr := range corpus.attrs {
+		names = append(names, attr.name)
+	}
+	return
+}
+
+// Returns the value of an attribute at a corpus position, or "" if the corpus has no such attribute.
+func (corpus *Corpus) Attribute(name string, cpos int) string {
+	voc, seq, found := corpus.layer(name)
+	if !found {
+		return ""
+	}
+	return voc.String(seq.At(cpos))
+}
+
+// Adds an attribute layer to the corpus, or replaces an existing one, given its value at each corpus position.
+func (corpus *Corpus) SetAttribute(name string, values []string) error {
+	if name == WordAttribute {
+		return fmt.Errorf("corpustools: the %s attribute cannot be replaced", WordAttribute)
+	}
+	if len(values) != corpus.seq.Len() {
+		return fmt.Errorf("corpustools: attribute %s has %d values for %d tokens", name, len(values), corpus.seq.Len())
+	}
+	attr := attribute{name: name, voc: NewVocabulary()}
+	seq := make([]int, len(values))
+	for cpos, value := range values {
+		seq[cpos] = attr.voc.add(value)
+	}
+	attr.seq = newIntArray(seq, attr.voc.Size()-1)
+	for i := range corpus.attrs {
+		if corpus.attrs[i].name == name {
+			corpus.attrs[i] = attr
+			return nil
+		}
+	}
+	corpus.attrs = append(corpus.attrs, attr)
+	return nil
+}
+
+// Returns the vocabulary and sequence of an attribute layer.
+func (corpus *Corpus) layer(name string) (voc *Vocabulary, seq intArray, found bool) {
+	if name == WordAttribute {
+		return corpus.voc, corpus.seq, true
+	}
+	for _, attr := range corpus.attrs {
+		if attr.name == name {
+			return attr.voc, attr.seq, true
+		}
+	}
+	return nil, nil, false
+}
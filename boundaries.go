@@ -0,0 +1,139 @@
+package corpustools
+
+import (
+	"sort"
+)
+
+// A corpus is divided into documents (e.g. input files), and documents into segments (e.g. lines, paragraphs or
+// sentences). Both are recorded as the corpus positions at which they start, so that searches can exclude matches which
+// span a boundary and each position can be mapped back to the document containing it.
+
+// How text is divided into segments as it is read.
+type Segmentation int
+
+const (
+	NoSegments        Segmentation = iota // Each document is a single segment.
+	LineSegments                          // Each line is a segment.
+	ParagraphSegments                     // Each run of lines separated by blank lines is a segment.
+)
+
+// A kind of boundary which a match may be required not to span.
+type Boundary int
+
+const (
+	NoBoundary       Boundary = iota // Matches may span any boundary.
+	SegmentBoundary                  // Matches must lie within a single segment.
+	DocumentBoundary                 // Matches must lie within a single document.
+)
+
+//
+// Documents and segments.
+//
+
+// Returns the number of documents in the corpus.
+func (corpus *Corpus) NumDocuments() int {
+	return corpus.docs.Len()
+}
+
+// Returns the number of segments in the corpus.
+func (corpus *Corpus) NumSegments() int {
+	return corpus.segs.Len()
+}
+
+// Returns the range of corpus positions [start, end) occupied by a document.
+func (corpus *Corpus) DocumentRange(doc int) (start, end int) {
+	return corpus.unitRange(corpus.docs, doc)
+}
+
+// Returns the range of corpus positions [start, end) occupied by a segment.
+func (corpus *Corpus) SegmentRange(seg int) (start, end int) {
+	return corpus.unitRange(corpus.segs, seg)
+}
+
+// Returns the document containing a corpus position, and the offset of the position within the document.
+func (corpus *Corpus) Locate(cpos int) (doc, offset int) {
+	doc = unitOf(corpus.docs, cpos)
+	return doc, cpos - corpus.docs.At(doc)
+}
+
+// Returns the segment containing a corpus position.
+func (corpus *Corpus) SegmentOf(cpos int) int {
+	return unitOf(corpus.segs, cpos)
+}
+
+// Returns whether the length tokens starting at a corpus position span a boundary of the given kind.
+func (corpus *Corpus) Crosses(cpos, length int, boundary Boundary) bool {
+	var starts intArray
+	switch boundary {
+	case SegmentBoundary:
+		starts = corpus.segs
+	case DocumentBoundary:
+		starts = corpus.docs
+	default:
+		return false
+	}
+	_, end := corpus.unitRange(starts, unitOf(starts, cpos))
+	return cpos+length > end
+}
+
+// Returns the range of corpus positions occupied by the unit with the given index in an array of unit starts.
+func (corpus *Corpus) unitRange(starts intArray, unit int) (start, end int) {
+	start, end = starts.At(unit), corpus.seq.Len()
+	if unit+1 < starts.Len() {
+		end = starts.At(unit + 1)
+	}
+	return
+}
+
+// Returns the index of the unit containing a corpus position, given an array of unit starts.
+func unitOf(starts intArray, cpos int) int {
+	return sort.Search(starts.Len(), func(i int) bool { return starts.At(i) > cpos }) - 1
+}
+
+// Returns the unit starts for a corpus with a single document and segment.
+func singleUnit(n int) (docs, segs intArray) {
+	docs = narrowArray{0}
+	segs = narrowArray{}
+	if n > 0 {
+		segs = narrowArray{0}
+	}
+	return
+}
+
+//
+// Search and frequency methods respecting boundaries.
+//
+
+// Returns the corpus indices where a given sequence occurs without spanning a boundary of the given kind.
+func (corpus *Corpus) FindWithin(seq []int, boundary Boundary) (indices []int) {
+	indices = make([]int, 0)
+	for _, cpos := range corpus.Find(seq) {
+		if !corpus.Crosses(cpos, len(seq), boundary) {
+			indices = append(indices, cpos)
+		}
+	}
+	return
+}
+
+// Returns the number of times a sequence occurs in the corpus without spanning a boundary of the given kind.
+func (corpus *Corpus) FrequencyWithin(seq []int, boundary Boundary) int {
+	if boundary == NoBoundary {
+		return corpus.Frequency(seq)
+	}
+	slo, shi := corpus.SuffixSearch(seq)
+	if slo == -1 {
+		return 0
+	}
+	return corpus.countWithin(slo, shi, len(seq), boundary)
+}
+
+// Returns the number of suffixes in the range [slo, shi] of the suffix array whose first length tokens do not span a
+// boundary of the given kind.
+func (corpus *Corpus) countWithin(slo, shi, length int, boundary Boundary) (count int) {
+	for spos := slo; spos <= shi; spos++ {
+		if !corpus.Crosses(corpus.sfx.At(spos), length, boundary) {
+			count++
+		}
+	}
+	return
+}
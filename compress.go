@@ -0,0 +1,78 @@
+package corpustools
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Input files may be compressed with gzip or bzip2, which is detected from their first bytes rather than their names, and
+// are decompressed as they are read. Zstandard is recognized but not supported, as the standard library cannot read it.
+
+// Returned when an input file is compressed in a format which cannot be read.
+type CompressionError struct {
+	Filename string
+	Format   string
+}
+
+func (e *CompressionError) Error() string {
+	return fmt.Sprintf("corpustools: %s: %s compression is not supported", e.Filename, e.Format)
+}
+
+// The magic bytes at the start of files compressed in each recognized format.
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh") // Followed by a block size from '1' to '9'.
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Returns whether data begins with the magic bytes of bzip2 and a block size, rather than being text beginning "BZh".
+func isBzip2(data []byte) bool {
+	return len(data) >= 4 && bytes.HasPrefix(data, bzip2Magic) && data[3] >= '1' && data[3] <= '9'
+}
+
+// Opens a file for reading, decompressing it if it is compressed. A missing file is reported as a FileNotFoundError.
+func openInput(filename string) (io.ReadCloser, error) {
+	fh, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(fh, filename)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return &inputFile{Reader: r, file: fh}, nil
+}
+
+// Returns a reader of the decompressed contents of r, or of r itself if it is not compressed.
+func decompress(r io.Reader, name string) (io.Reader, error) {
+	bfr := bufio.NewReader(r)
+	magic, _ := bfr.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(bfr)
+		if err != nil {
+			return nil, &DecodeError{Filename: name, Line: 1, Err: err}
+		}
+		return zr, nil
+	case isBzip2(magic):
+		return bzip2.NewReader(bfr), nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, &CompressionError{Filename: name, Format: "zstd"}
+	}
+	return bfr, nil
+}
+
+// An input file read through a decompressor.
+type inputFile struct {
+	io.Reader
+	file io.Closer
+}
+
+func (f *inputFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,193 @@
+package corpustools
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// A keyword-in-context (KWIC) line: an occurrence of a sequence with the tokens to its left and right.
+type ConcordanceLine struct {
+	Position int      `json:"position"` // The corpus position of the keyword.
+	Document int      `json:"document"` // The document containing the keyword.
+	Left     []string `json:"left"`     // The tokens preceding the keyword, in corpus order.
+	Keyword  []string `json:"keyword"`  // The tokens of the keyword.
+	Right    []string `json:"right"`    // The tokens following the keyword.
+}
+
+// Orders in which concordance lines can be sorted.
+type ConcordanceSort int
+
+const (
+	CorpusOrder ConcordanceSort = iota // In order of position.
+	SortRight                          // By the tokens following the keyword, nearest first.
+	SortLeft                           // By the tokens preceding the keyword, nearest first.
+)
+
+// Options for Concordance.
+type ConcordanceOptions struct {
+	Left   int             // The number of tokens of context to the left of the keyword (none if negative).
+	Right  int             // The number of tokens of context to the right of the keyword (none if negative).
+	Sort   ConcordanceSort // The order of the lines.
+	Sample int             // If positive, the number of occurrences to choose at random (before sorting).
+	Seed   int64           // The seed of the random choice of occurrences.
+	Within Boundary        // Occurrences spanning a boundary of this kind are skipped, and context stops at one.
+}
+
+// Returns the keyword-in-context lines for the occurrences of a sequence. Lines sorted by context are ordered by the
+// strings of their context tokens within the widths of the options, and lines with the same context by position.
+func (corpus *Corpus) Concordance(seq []int, options ConcordanceOptions) (lines []ConcordanceLine) {
+	if options.Left < 0 {
+		options.Left = 0
+	}
+	if options.Right < 0 {
+		options.Right = 0
+	}
+	slo, shi := corpus.SuffixSearch(seq)
+	indices := make([]int, 0)
+	for spos := slo; spos <= shi && slo != -1; spos++ {
+		if cpos := corpus.sfx.At(spos); !corpus.Crosses(cpos, len(seq), options.Within) {
+			indices = append(indices, cpos)
+		}
+	}
+	if options.Sample > 0 && options.Sample < len(indices) {
+		chosen := make([]bool, len(indices))
+		for _, i := range rand.New(rand.NewSource(options.Seed)).Perm(len(indices))[:options.Sample] {
+			chosen[i] = true
+		}
+		sampled := indices[:0]
+		for i, cpos := range indices {
+			if chosen[i] {
+				sampled = append(sampled, cpos)
+			}
+		}
+		indices = sampled
+	}
+	sort.Ints(indices)
+	lines = make([]ConcordanceLine, len(indices))
+	for i, cpos := range indices {
+		lines[i] = corpus.concordanceLine(cpos, len(seq), options)
+	}
+	// Stable sorts keep lines with the same context in order of position.
+	switch options.Sort {
+	case SortRight:
+		sort.SliceStable(lines, func(i, j int) bool { return cmpContext(lines[i].Right, lines[j].Right, false) < 0 })
+	case SortLeft:
+		sort.SliceStable(lines, func(i, j int) bool {
+			if c := cmpContext(lines[i].Left, lines[j].Left, true); c != 0 {
+				return c < 0
+			}
+			return cmpContext(lines[i].Right, lines[j].Right, false) < 0
+		})
+	}
+	return
+}
+
+// Returns the concordance line for the occurrence of a sequence of the given length at a corpus position.
+func (corpus *Corpus) concordanceLine(cpos, length int, options ConcordanceOptions) (line ConcordanceLine) {
+	start, end := corpus.contextRange(cpos, options.Within)
+	left, right := cpos-options.Left, cpos+length+options.Right
+	if left < start {
+		left = start
+	}
+	if right > end {
+		right = end
+	}
+	line.Position = cpos
+	line.Document, _ = corpus.Locate(cpos)
+	line.Left = corpus.ToString(sliceOf(corpus.seq, left, cpos))
+	line.Keyword = corpus.ToString(sliceOf(corpus.seq, cpos, cpos+length))
+	line.Right = corpus.ToString(sliceOf(corpus.seq, cpos+length, right))
+	return
+}
+
+// Returns the range of corpus positions [start, end) to which the context of a corpus position is confined by boundaries
+// of the given kind.
+func (corpus *Corpus) contextRange(cpos int, boundary Boundary) (start, end int) {
+	switch boundary {
+	case SegmentBoundary:
+		return corpus.SegmentRange(corpus.SegmentOf(cpos))
+	case DocumentBoundary:
+		doc, _ := corpus.Locate(cpos)
+		return corpus.DocumentRange(doc)
+	}
+	return 0, corpus.seq.Len()
+}
+
+// Compares two contexts by the strings of their tokens, nearest token first, where the nearest token of a left context
+// is its last. A context cut short sorts before any longer context it is a prefix of.
+func cmpContext(ctx1, ctx2 []string, left bool) int {
+	for i := 0; i < len(ctx1) && i < len(ctx2); i++ {
+		t1, t2 := ctx1[i], ctx2[i]
+		if left {
+			t1, t2 = ctx1[len(ctx1)-1-i], ctx2[len(ctx2)-1-i]
+		}
+		if c := strings.Compare(t1, t2); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(ctx1) < len(ctx2):
+		return -1
+	case len(ctx1) > len(ctx2):
+		return 1
+	}
+	return 0
+}
+
+//
+// Output of concordance lines.
+//
+
+// Formats in which concordance lines can be written.
+type ConcordanceFormat int
+
+const (
+	TextFormat ConcordanceFormat = iota // Aligned plain text, with the keywords in a column.
+	TSVFormat                           // Tab-separated position, document, left context, keyword and right context, with a header row.
+	JSONFormat                          // A JSON array of objects with the fields of ConcordanceLine.
+)
+
+// Writes concordance lines to w in the given format.
+func WriteConcordance(w io.Writer, lines []ConcordanceLine, format ConcordanceFormat) (err error) {
+	switch format {
+	case TextFormat:
+		width := 0
+		for _, line := range lines {
+			if n := len([]rune(strings.Join(line.Left, " "))); n > width {
+				width = n
+			}
+		}
+		for _, line := range lines {
+			left := strings.Join(line.Left, " ")
+			padding := strings.Repeat(" ", width-len([]rune(left)))
+			if _, err = fmt.Fprintf(w, "%s%s  %s  %s\n", padding, left, strings.Join(line.Keyword, " "), strings.Join(line.Right, " ")); err != nil {
+				return
+			}
+		}
+	case TSVFormat:
+		if _, err = fmt.Fprintln(w, "position\tdocument\tleft\tkeyword\tright"); err != nil {
+			return
+		}
+		for _, line := range lines {
+			if _, err = fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", line.Position, line.Document, tsvField(line.Left), tsvField(line.Keyword), tsvField(line.Right)); err != nil {
+				return
+			}
+		}
+	case JSONFormat:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(lines)
+	default:
+		err = fmt.Errorf("corpustools: unknown concordance format %d", format)
+	}
+	return
+}
+
+// Joins tokens with spaces, replacing any tabs or line breaks within them so the result is a single TSV field.
+func tsvField(tokens []string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(strings.Join(tokens, " "))
+}
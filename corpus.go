@@ -2,51 +2,149 @@ package corpustools
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"math"
+	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 )
 
 // The Corpus object and its methods.
 type Corpus struct {
-	voc map[string]int // Mapping from input string tokens to unique integers.
-	seq []int          // The raw data of the corpus stored as a sequence of integers.
-	sfx []int          // The suffix array, containing of slices of all suffixes of the corpus.
+	voc *Vocabulary    // Mapping between input string tokens and unique integers.
+	seq intArray       // The raw data of the corpus stored as a sequence of integers.
+	sfx intArray       // The suffix array, containing of slices of all suffixes of the corpus.
+	lcp intArray       // The optional LCP array, containing the common prefix lengths of adjacent suffixes (see lcp.go).
+	docs intArray      // The corpus positions at which documents start (see boundaries.go).
+	segs intArray      // The corpus positions at which segments start.
+
+	attrs     []attribute         // Annotation layers aligned with seq, such as lemmas or parts of speech (see attributes.go).
+	meta      []map[string]string // The metadata fields of each document, or nil if there are none (see documents.go).
+	tokenizer Tokenizer           // The tokenizer used to tokenize text queries, or nil for a corpus loaded from an index.
+	mapping   []byte              // The memory-mapped index holding seq and sfx, if the corpus was opened with MapCorpus.
 }
 
 func (corpus *Corpus) Info() string {
-	return fmt.Sprintf("%d types and %d tokens in the corpus; %d suffixes in the suffix array.", len(corpus.voc), len(corpus.seq), len(corpus.sfx))
+	return fmt.Sprintf("%d types and %d tokens in the corpus; %d suffixes in the suffix array.", corpus.voc.Size(), corpus.seq.Len(), corpus.sfx.Len())
 }
 
-//
-// Sort interface methods.
-//
-
+// Returns the number of tokens in the corpus.
 func (corpus *Corpus) Len() int {
-	return len(corpus.seq)
+	return corpus.seq.Len()
 }
 
+// Swaps two entries of the suffix array, so that a Corpus implements sort.Interface over its suffixes.
+//
+// Deprecated: the suffix array is built in order and need not be sorted. A packed or memory-mapped suffix array is
+// copied into memory before it is changed.
 func (corpus *Corpus) Swap(i, j int) {
-	corpus.sfx[i], corpus.sfx[j] = corpus.sfx[j], corpus.sfx[i]
+	if _, packed := corpus.sfx.(*packedArray); packed || corpus.isMapped(corpus.sfx) {
+		corpus.sfx = newIntArray(intsOf(corpus.sfx), corpus.seq.Len())
+	}
+	switch sfx := corpus.sfx.(type) {
+	case narrowArray:
+		sfx[i], sfx[j] = sfx[j], sfx[i]
+	case wideArray:
+		sfx[i], sfx[j] = sfx[j], sfx[i]
+	}
 }
 
+// Returns whether the suffix at position i of the suffix array is less than that at position j.
+//
+// Deprecated: see Swap.
 func (corpus *Corpus) Less(i, j int) bool {
-	if SeqCmp(corpus.seq[corpus.sfx[i]:], corpus.seq[corpus.sfx[j]:]) == -1 {
-		return true
+	cpos1, cpos2 := corpus.sfx.At(i), corpus.sfx.At(j)
+	length := corpus.commonPrefix(cpos1, cpos2, corpus.seq.Len())
+	if cpos2+length == corpus.seq.Len() {
+		// The second suffix is a prefix of the first, so the first is not less.
+		return false
+	}
+	return cpos1+length == corpus.seq.Len() || corpus.seq.At(cpos1+length) < corpus.seq.At(cpos2+length)
+}
+
+//
+// Storage.
+//
+
+// Stores the corpus tokens (and any attribute layers) bit-packed, using only as many bits per token as the size of the
+// vocabulary requires. This reduces memory use further than the 32-bit storage used by default, at some cost in search
+// speed.
+func (corpus *Corpus) PackTokens() {
+	corpus.seq = packTokens(corpus.seq, corpus.voc)
+	for i := range corpus.attrs {
+		corpus.attrs[i].seq = packTokens(corpus.attrs[i].seq, corpus.attrs[i].voc)
+	}
+}
+
+// Returns a sequence of types from a vocabulary stored bit-packed, if that is narrower than its current layout.
+func packTokens(seq intArray, voc *Vocabulary) intArray {
+	width := bitsFor(voc.Size() - 1)
+	if _, packed := seq.(*packedArray); !packed && width < 32 {
+		return newPackedArray(seq, width)
 	}
-	return false
+	return seq
 }
 
 //
 // Suffix array.
 //
 
+// Computes the suffix array of the corpus in linear time (see sais.go).
 func (corpus *Corpus) SetSuffixArray() {
-	corpus.sfx = make([]int, len(corpus.seq))
-	for i := 0; i < len(corpus.seq); i++ {
-		corpus.sfx[i] = i
+	corpus.setSuffixArray(intsOf(corpus.seq))
+}
+
+// Computes the suffix array from a copy of the corpus sequence.
+func (corpus *Corpus) setSuffixArray(seq []int) {
+	k := 0
+	for _, type_int := range seq {
+		if type_int >= k {
+			k = type_int + 1
+		}
+	}
+	sfx := make([]int, len(seq))
+	sais(seq, sfx, k)
+	corpus.sfx = newIntArray(sfx, len(seq)-1)
+	corpus.lcp = nil
+}
+
+// Compares the suffix starting at corpus position cpos with a sequence over the length of the sequence, in the manner of
+// SeqCmpLimited.
+func (corpus *Corpus) cmpSuffix(cpos int, seq []int) int {
+	length := corpus.seq.Len() - cpos
+	if len(seq) < length {
+		length = len(seq)
+	}
+	if arr, narrow := corpus.seq.(narrowArray); narrow {
+		// Avoid the cost of an interface call per element for the default layout.
+		for pos, type_int := range arr[cpos : cpos+length] {
+			if int(type_int) != seq[pos] {
+				if int(type_int) < seq[pos] {
+					return -1
+				}
+				return 1
+			}
+		}
+	} else {
+		for pos := 0; pos < length; pos++ {
+			type_int := corpus.seq.At(cpos + pos)
+			if type_int < seq[pos] {
+				return -1
+			}
+			if type_int > seq[pos] {
+				return 1
+			}
+		}
 	}
-	sort.Sort(corpus)
+	// The suffix matches as far as it goes, so it is lesser if it is shorter than the sequence.
+	if length == len(seq) {
+		return 0
+	}
+	return -1
 }
 
 //
@@ -56,10 +154,13 @@ func (corpus *Corpus) SetSuffixArray() {
 // Returns the corpus indices where a given sequence occurs.
 func (corpus *Corpus) Find(seq []int) (indices []int) {
 	slo, shi := corpus.SuffixSearch(seq)
+	if slo == -1 {
+		return make([]int, 0)
+	}
 	indices = make([]int, shi-slo+1)
 	i := 0
 	for spos := slo; spos <= shi; spos++ {
-		indices[i] = corpus.sfx[spos]
+		indices[i] = corpus.sfx.At(spos)
 		i++
 	}
 	return
@@ -67,7 +168,7 @@ func (corpus *Corpus) Find(seq []int) (indices []int) {
 
 // Binary search over suffix array to find suffix range where a sequence is located.
 func (corpus *Corpus) SuffixSearch(seq []int) (int, int) {
-	slo, right_bound := corpus.binarySearchMin(seq, 0, len(corpus.sfx)-1)
+	slo, right_bound := corpus.binarySearchMin(seq, 0, corpus.sfx.Len()-1)
 	if slo == -1 {
 		return -1, -1
 	}
@@ -77,10 +178,10 @@ func (corpus *Corpus) SuffixSearch(seq []int) (int, int) {
 
 // Slow linear search over corpus. Only useful for testing so not exported.
 func (corpus *Corpus) slowSearch(seq []int) (slo, shi int) {
-	slo = len(corpus.sfx) - 1
+	slo = corpus.sfx.Len() - 1
 	shi = 0
-	for spos := 0; spos < len(corpus.sfx); spos++ {
-		if SeqCmpLimited(corpus.seq[corpus.sfx[spos]:], seq, len(seq)) == 0 {
+	for spos := 0; spos < corpus.sfx.Len(); spos++ {
+		if corpus.cmpSuffix(corpus.sfx.At(spos), seq) == 0 {
 			if spos < slo {
 				slo = spos
 			}
@@ -99,7 +200,7 @@ func (corpus *Corpus) binarySearchMin(seq []int, smin, smax int) (int, right_bou
 	for smax > smin {
 		// Compare the ngram found at the search location with the desired ngram. 
 		smid := (smin + smax) / 2
-		cmp := SeqCmpLimited(corpus.seq[corpus.sfx[smid]:], seq, len(seq))
+		cmp := corpus.cmpSuffix(corpus.sfx.At(smid), seq)
 		// Update the right bound.
 		if cmp == 1 && smid < right_bound {
 			right_bound = smid
@@ -111,7 +212,7 @@ func (corpus *Corpus) binarySearchMin(seq []int, smin, smax int) (int, right_bou
 			smax = smid
 		}
 	}
-	if (smax == smin) && SeqCmpLimited(seq, corpus.seq[corpus.sfx[smin]:], len(seq)) == 0 {
+	if (smax == smin) && corpus.cmpSuffix(corpus.sfx.At(smin), seq) == 0 {
 		return smin, right_bound
 	}
 	return -1, -1
@@ -124,7 +225,7 @@ func (corpus *Corpus) binarySearchMax(seq []int, smin, smax int) (int, left_boun
 	for smax > smin {
 		// Compare the ngram found at the search location with the desired ngram. 
 		smid := ((smin + smax) / 2) + 1
-		cmp := SeqCmpLimited(corpus.seq[corpus.sfx[smid]:], seq, len(seq))
+		cmp := corpus.cmpSuffix(corpus.sfx.At(smid), seq)
 		// Update the right bound.
 		if cmp == -1 && smid > left_bound {
 			left_bound = smid
@@ -136,7 +237,7 @@ func (corpus *Corpus) binarySearchMax(seq []int, smin, smax int) (int, left_boun
 			smin = smid
 		}
 	}
-	if (smax == smin) && SeqCmpLimited(seq, corpus.seq[corpus.sfx[smin]:], len(seq)) == 0 {
+	if (smax == smin) && corpus.cmpSuffix(corpus.sfx.At(smin), seq) == 0 {
 		return smin, left_bound
 	}
 	return -1, -1
@@ -148,21 +249,21 @@ func (corpus *Corpus) binarySearchMax(seq []int, smin, smax int) (int, left_boun
 
 // Returns a copy of the corpus.
 func (corpus *Corpus) Corpus() (seq []int) {
-	for cpos := 0; cpos < len(corpus.seq); cpos++ {
-		seq = append(seq, corpus.seq[cpos])
-	}
-	return
+	return intsOf(corpus.seq)
+}
+
+// Returns a read-only view of the vocabulary mapping between the corpus's string tokens and integers.
+func (corpus *Corpus) Vocabulary() *Vocabulary {
+	return corpus.voc.view()
 }
 
 // Converts a corpus sequence back into its input form.
 func (corpus *Corpus) ToString(seq []int) (strings []string) {
+	strings = make([]string, 0, len(seq))
 	for pos := 0; pos < len(seq); pos++ {
 		str := "**UNKNOWN**"
-		for type_str, type_int := range corpus.voc {
-			if type_int == seq[pos] {
-				str = type_str
-				break
-			}
+		if seq[pos] >= 0 && seq[pos] < corpus.voc.Size() {
+			str = corpus.voc.String(seq[pos])
 		}
 		strings = append(strings, str)
 	}
@@ -173,14 +274,74 @@ func (corpus *Corpus) ToString(seq []int) (strings []string) {
 // Ngram methods.
 //
 
+// Returns all distinct ngrams of a given order in the corpus, in suffix order. EachNgram enumerates ngrams without
+// holding them all in memory, and provides their frequencies.
 func (corpus *Corpus) Ngrams(order int) (ngrams [][]int) {
-	for spos := 0; spos < len(corpus.sfx); spos++ {
-		corpus_slice := corpus.seq[corpus.sfx[spos]:]
-		if (len(corpus_slice) >= order) && (len(ngrams) == 0 || SeqCmpLimited(ngrams[len(ngrams)-1], corpus_slice, order) != 0) {
-			ngrams = append(ngrams, corpus_slice[:order])
+	corpus.EachNgram(NgramOptions{MinOrder: order}, func(ngram []int, frequency, slo, shi int) bool {
+		ngrams = append(ngrams, append([]int(nil), ngram...))
+		return true
+	})
+	return
+}
+
+// Options for EachNgram.
+type NgramOptions struct {
+	MinOrder     int // The shortest ngrams to enumerate.
+	MaxOrder     int // The longest ngrams to enumerate (if less than MinOrder, only ngrams of MinOrder are enumerated).
+	MinFrequency int // Ngrams occurring fewer times than this are skipped.
+	Within       Boundary // Occurrences spanning a boundary of this kind are not counted.
+}
+
+// Calls fn with each distinct ngram in the corpus whose order and frequency are in the ranges given by the options, along
+// with its frequency and the range [slo, shi] of the suffix array where it occurs (which includes any occurrences which
+// span a boundary and so are not counted in the frequency). Ngrams are enumerated in suffix order
+// for each order in turn, in a single pass over the suffix array per order. Enumeration stops early if fn returns false.
+// The ngram slice is reused between calls, so it must be copied if it is to be retained.
+func (corpus *Corpus) EachNgram(options NgramOptions, fn func(ngram []int, frequency, slo, shi int) bool) {
+	max_order := options.MaxOrder
+	if max_order < options.MinOrder {
+		max_order = options.MinOrder
+	}
+	n := corpus.sfx.Len()
+	for order := options.MinOrder; order <= max_order; order++ {
+		if order < 1 {
+			continue
+		}
+		ngram := make([]int, order)
+		// Adjacent suffixes start the same ngram if they share at least order tokens, so each ngram occupies a run of
+		// the suffix array ended by a shorter common prefix (suffixes shorter than order never share order tokens).
+		slo := -1
+		for spos := 0; spos <= n; spos++ {
+			if slo != -1 && (spos == n || corpus.commonPrefixAt(spos, order) < order) {
+				frequency := spos - slo
+				if options.Within != NoBoundary && frequency >= options.MinFrequency {
+					frequency = corpus.countWithin(slo, spos-1, order, options.Within)
+				}
+				if frequency >= options.MinFrequency && frequency > 0 {
+					cpos := corpus.sfx.At(slo)
+					for i := range ngram {
+						ngram[i] = corpus.seq.At(cpos + i)
+					}
+					if !fn(ngram, frequency, slo, spos-1) {
+						return
+					}
+				}
+				slo = -1
+			}
+			if slo == -1 && spos < n && corpus.sfx.At(spos)+order <= corpus.seq.Len() {
+				slo = spos
+			}
 		}
 	}
-	return
+}
+
+// Returns the length of the common prefix of the suffixes at positions spos-1 and spos of the suffix array, up to a
+// limit, using the LCP array if it has been computed.
+func (corpus *Corpus) commonPrefixAt(spos, limit int) int {
+	if corpus.lcp != nil {
+		return corpus.lcp.At(spos)
+	}
+	return corpus.commonPrefix(corpus.sfx.At(spos-1), corpus.sfx.At(spos), limit)
 }
 
 //
@@ -189,17 +350,22 @@ func (corpus *Corpus) Ngrams(order int) (ngrams [][]int) {
 
 // Returns the number of times a sequence occurs in the corpus.
 func (corpus *Corpus) Frequency(seq []int) int {
-	slo, shi := corpus.SuffixSearch(seq)
+	slo, right_bound := corpus.binarySearchMin(seq, 0, corpus.sfx.Len()-1)
 	if slo == -1 {
 		return 0
 	}
+	// The LCP array shows directly when the next suffix does not share the sequence, which is common for long sequences.
+	if corpus.lcp != nil && (slo == corpus.sfx.Len()-1 || corpus.lcp.At(slo+1) < len(seq)) {
+		return 1
+	}
+	shi, _ := corpus.binarySearchMax(seq, slo, right_bound)
 	return (shi - slo) + 1
 }
 
 // Returns the probability of a sequence in the corpus.
 func (corpus *Corpus) Probability(seq []int) float64 {
 	f := corpus.Frequency(seq)
-	return float64(f) / float64(len(corpus.seq)-(len(seq)-1))
+	return float64(f) / float64(corpus.seq.Len()-(len(seq)-1))
 }
 
 // Returns the P(seq2 | seq1) in the corpus.
@@ -309,15 +475,18 @@ func (corpus *Corpus) CoocVector(seq []int) (cooc *Cooc) {
 	slo, shi := corpus.SuffixSearch(seq)
 	// Get the frequency counts.
 	cooc = &Cooc{seq: seq, dat: make(map[int]float64)}
+	if slo == -1 {
+		return
+	}
 	for spos := slo; spos <= shi; spos++ {
-		cpos := corpus.sfx[spos]
+		cpos := corpus.sfx.At(spos)
 		// Increment the count of the type occurring before the sequence.
 		if cpos > 0 {
-			cooc.Inc(-corpus.seq[cpos-1])
+			cooc.Inc(-corpus.seq.At(cpos - 1))
 		}
 		// Increment the count of the type occurring after the sequence.
-		if cpos < len(corpus.seq)-lseq {
-			cooc.Inc(corpus.seq[cpos+lseq])
+		if cpos < corpus.seq.Len()-lseq {
+			cooc.Inc(corpus.seq.At(cpos + lseq))
 		}
 	}
 	return
@@ -327,25 +496,247 @@ func (corpus *Corpus) CoocVector(seq []int) (cooc *Cooc) {
 // Functions to create a corpus.
 //
 
-// Creates and returns a corpus from a text file.
+// Creates and returns a corpus from a text file, exiting the program if the file cannot be read.
 func CorpusFromFile(filename string, lowerCase bool, returnChars bool) (corpus *Corpus) {
-	// Initialize the corpus.
-	corpus = &Corpus{voc: make(map[string]int), seq: make([]int, 0), sfx: nil}
-	// Get string array from tokenizer.
-	tokens := TokensFromFile(filename, lowerCase, returnChars)
-	// Iterate through the string tokens.
-	type_ctr := 0
+	corpus, err := NewCorpusFromFile(filename, ClassicTokenizer{LowerCase: lowerCase, ReturnChars: returnChars}, NoSegments)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return
+}
+
+// Creates and returns a corpus from a text file, splitting each line into tokens with a tokenizer and dividing the text
+// into segments as specified. The file is a single document, and may be compressed with gzip or bzip2 (see compress.go).
+func NewCorpusFromFile(filename string, tokenizer Tokenizer, segmentation Segmentation) (corpus *Corpus, err error) {
+	return CorpusReader{Tokenizer: tokenizer, Segmentation: segmentation}.ReadFile(filename)
+}
+
+// Creates and returns a corpus from a list of text files, each of which becomes a document whose "filename" metadata
+// field is set to its name. Lines are split into tokens and segments as in NewCorpusFromFile.
+func NewCorpusFromFiles(filenames []string, tokenizer Tokenizer, segmentation Segmentation) (corpus *Corpus, err error) {
+	return CorpusReader{Tokenizer: tokenizer, Segmentation: segmentation}.ReadFiles(filenames)
+}
+
+// Creates and returns a corpus from the text files matching a pattern (as in filepath.Match), in lexical order of their
+// names, each of which becomes a document. A FileNotFoundError is returned if no file matches.
+func NewCorpusFromGlob(pattern string, tokenizer Tokenizer, segmentation Segmentation) (corpus *Corpus, err error) {
+	filenames, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(filenames) == 0 {
+		return nil, &FileNotFoundError{Filename: pattern, Err: os.ErrNotExist}
+	}
+	return NewCorpusFromFiles(filenames, tokenizer, segmentation)
+}
+
+// Creates and returns a corpus from every regular file in a directory tree, in lexical order of their paths, each of
+// which becomes a document. Files and directories whose names begin with a dot are skipped.
+func NewCorpusFromDir(dir string, tokenizer Tokenizer, segmentation Segmentation) (corpus *Corpus, err error) {
+	filenames := make([]string, 0)
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return &FileNotFoundError{Filename: path, Err: err}
+			}
+			return err
+		}
+		if path != dir && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			filenames = append(filenames, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewCorpusFromFiles(filenames, tokenizer, segmentation)
+}
+
+// Creates and returns a corpus from the text read from r (e.g. os.Stdin or a network stream), which is decompressed if
+// it is compressed with gzip or bzip2.
+func NewCorpusFromReader(r io.Reader, tokenizer Tokenizer, segmentation Segmentation) (corpus *Corpus, err error) {
+	return CorpusReader{Tokenizer: tokenizer, Segmentation: segmentation}.Read(r)
+}
+
+// Settings for reading text into a corpus. The constructors above read with a CorpusReader which allows lines of any
+// length; a CorpusReader with a MaxLineLength guards against input without line breaks, such as a binary file read by
+// mistake. Each CorpusReader is independent, so different settings can be used at the same time.
+type CorpusReader struct {
+	Tokenizer     Tokenizer    // Splits each line into tokens.
+	Segmentation  Segmentation // Divides the text into segments.
+	MaxLineLength int          // The maximum number of bytes in a line of input, or 0 for no limit.
+}
+
+// Creates and returns a corpus from a text file, as in NewCorpusFromFile.
+func (reader CorpusReader) ReadFile(filename string) (corpus *Corpus, err error) {
+	fh, err := openInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return reader.read(fh, filename)
+}
+
+// Creates and returns a corpus from a list of text files, as in NewCorpusFromFiles.
+func (reader CorpusReader) ReadFiles(filenames []string) (corpus *Corpus, err error) {
+	builder := newCorpusBuilder()
+	for _, filename := range filenames {
+		if err = builder.readFile(filename, reader); err != nil {
+			return nil, err
+		}
+	}
+	corpus = builder.corpus()
+	corpus.tokenizer = reader.Tokenizer
+	return
+}
+
+// Creates and returns a corpus from the text read from r, as in NewCorpusFromReader.
+func (reader CorpusReader) Read(r io.Reader) (corpus *Corpus, err error) {
+	if r, err = decompress(r, "<reader>"); err != nil {
+		return nil, err
+	}
+	return reader.read(r, "<reader>")
+}
+
+func (reader CorpusReader) read(r io.Reader, name string) (corpus *Corpus, err error) {
+	builder := newCorpusBuilder()
+	builder.startDocument(nil)
+	if err = builder.read(r, name, reader); err != nil {
+		return nil, err
+	}
+	corpus = builder.corpus()
+	corpus.tokenizer = reader.Tokenizer
+	return
+}
+
+// Creates and returns a corpus from a sequence of tokens which have already been tokenized.
+func NewCorpusFromTokens(tokens []string) (corpus *Corpus) {
+	builder := newCorpusBuilder()
+	builder.startDocument(nil)
 	for _, token := range tokens {
-		// Get the unique identifier for the token.
-		_, found := corpus.voc[token]
-		if !found {
-			corpus.voc[token] = type_ctr
-			type_ctr++
+		builder.add(token)
+	}
+	return builder.corpus()
+}
+
+// Creates and returns a corpus from a sequence of integers and the vocabulary mapping tokens to those integers. Every
+// element of seq must be in the vocabulary. Both arguments are copied.
+func NewCorpusFromInts(seq []int, voc *Vocabulary) (corpus *Corpus, err error) {
+	// Check the sequence only uses integers in the vocabulary.
+	for cpos, type_int := range seq {
+		if type_int < 0 || type_int >= voc.Size() {
+			return nil, fmt.Errorf("corpustools: sequence element %d at position %d is not in the vocabulary", type_int, cpos)
+		}
+	}
+	// Copy the inputs into the corpus.
+	voc, _ = NewVocabularyFromStrings(voc.strs)
+	corpus = &Corpus{voc: voc, seq: newIntArray(seq, voc.Size()-1), sfx: nil, tokenizer: WhitespaceTokenizer{}}
+	corpus.docs, corpus.segs = singleUnit(len(seq))
+	corpus.setSuffixArray(seq)
+	return
+}
+
+// Accumulates string tokens into a new corpus, assigning each distinct token a unique integer in order of appearance,
+// and records the boundaries of its documents and segments.
+type corpusBuilder struct {
+	voc         *Vocabulary
+	seq         []int
+	docs        []int
+	segs        []int
+	meta        []map[string]string
+	attrs       []attribute // The attribute layers, whose sequences are held in attr_seqs until the corpus is built.
+	attr_seqs   [][]int
+	pending_seg bool // Whether a segment starts at the next token.
+}
+
+func newCorpusBuilder() *corpusBuilder {
+	return &corpusBuilder{voc: NewVocabulary(), seq: make([]int, 0)}
+}
+
+// Starts a new document (and segment) at the next token, with the given metadata fields (which may be nil).
+func (builder *corpusBuilder) startDocument(fields map[string]string) {
+	builder.docs = append(builder.docs, len(builder.seq))
+	builder.meta = append(builder.meta, fields)
+	builder.pending_seg = true
+}
+
+// Starts a new segment at the next token. Empty segments are not recorded.
+func (builder *corpusBuilder) startSegment() {
+	builder.pending_seg = true
+}
+
+// Reads a text file into a new document, recording its name in the document's metadata.
+func (builder *corpusBuilder) readFile(filename string, reader CorpusReader) error {
+	fh, err := openInput(filename)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	builder.startDocument(map[string]string{"filename": filename})
+	return builder.read(fh, filename, reader)
+}
+
+// Tokenizes the lines of text read from r into the current document, dividing them into segments with the reader's
+// settings.
+func (builder *corpusBuilder) read(r io.Reader, name string, reader CorpusReader) error {
+	return eachLine(r, name, reader.MaxLineLength, func(line string) error {
+		if reader.Segmentation == LineSegments || (reader.Segmentation == ParagraphSegments && strings.TrimSpace(line) == "") {
+			builder.startSegment()
+		}
+		for _, token := range reader.Tokenizer.Tokenize(line) {
+			builder.add(token)
+		}
+		return nil
+	})
+}
+
+// Appends a token to the corpus being built.
+func (builder *corpusBuilder) add(token string) {
+	if builder.pending_seg {
+		builder.segs = append(builder.segs, len(builder.seq))
+		builder.pending_seg = false
+	}
+	// Get the unique identifier for the token and populate the corpus.
+	builder.seq = append(builder.seq, builder.voc.add(token))
+}
+
+// Declares the attribute layers of the corpus being built, whose values are given to addAnnotated.
+func (builder *corpusBuilder) setAttributes(names []string) {
+	for _, name := range names {
+		builder.attrs = append(builder.attrs, attribute{name: name, voc: NewVocabulary()})
+		builder.attr_seqs = append(builder.attr_seqs, make([]int, 0))
+	}
+}
+
+// Appends a token to the corpus being built together with the value of each of its attributes, in the order they were
+// declared.
+func (builder *corpusBuilder) addAnnotated(token string, values []string) {
+	builder.add(token)
+	for i, value := range values {
+		builder.attr_seqs[i] = append(builder.attr_seqs[i], builder.attrs[i].voc.add(value))
+	}
+}
+
+// Returns the corpus that has been built, with its suffix array computed.
+func (builder *corpusBuilder) corpus() (corpus *Corpus) {
+	corpus = &Corpus{voc: builder.voc, seq: newIntArray(builder.seq, builder.voc.Size()-1), sfx: nil, tokenizer: WhitespaceTokenizer{}}
+	corpus.docs, corpus.segs = newIntArray(builder.docs, len(builder.seq)), newIntArray(builder.segs, len(builder.seq))
+	for _, fields := range builder.meta {
+		if len(fields) > 0 {
+			corpus.meta = builder.meta
+			break
 		}
-		// Populate the corpus.
-		corpus.seq = append(corpus.seq, corpus.voc[token])
 	}
-	// Compute the suffix array.
-	corpus.SetSuffixArray()
+	for i, attr := range builder.attrs {
+		attr.seq = newIntArray(builder.attr_seqs[i], attr.voc.Size()-1)
+		corpus.attrs = append(corpus.attrs, attr)
+	}
+	corpus.setSuffixArray(builder.seq)
 	return
-}
\ No newline at end of file
+}
@@ -1,9 +1,19 @@
 package corpustools
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 // Iterate up to trigrams for test purposes.
@@ -18,19 +28,20 @@ var corpus = CorpusFromFile(strings.Join([]string{path, "/data/test_corpus.txt"}
 // Length of corpus and suffix array should be the same.
 func TestBasics(t *testing.T) {
 	// Length of corpus and suffix array should be the same.
-	if len(corpus.seq) != len(corpus.sfx) {
-		t.Errorf("Corpus sequence and suffix arrays are not the same length (%d vs. %d)!", len(corpus.seq), len(corpus.sfx))
+	if corpus.seq.Len() != corpus.sfx.Len() {
+		t.Errorf("Corpus sequence and suffix arrays are not the same length (%d vs. %d)!", corpus.seq.Len(), corpus.sfx.Len())
 	}
 	// i + 1th suffix ngram should be >= ith suffix ngram.
-	for spos := 0; spos < len(corpus.sfx)-1; spos++ {
-		if SeqCmp(corpus.seq[corpus.sfx[spos]:], corpus.seq[corpus.sfx[spos+1]:]) == 1 {
+	seq, sfx := intsOf(corpus.seq), intsOf(corpus.sfx)
+	for spos := 0; spos < len(sfx)-1; spos++ {
+		if SeqCmp(seq[sfx[spos]:], seq[sfx[spos+1]:]) == 1 {
 			t.Errorf("Suffix ordering error detected at positions %d and %d!\n", spos, spos+1)
 		}
 	}
 	// Number of unigrams should equal the size of the vocabulary.
 	unigrams := corpus.Ngrams(1)
-	if len(unigrams) != len(corpus.voc) {
-		t.Errorf("Number of unigrams is not equal to the size of the vocabulary (%d vs. %d)!", len(unigrams), len(corpus.voc))
+	if len(unigrams) != corpus.voc.Size() {
+		t.Errorf("Number of unigrams is not equal to the size of the vocabulary (%d vs. %d)!", len(unigrams), corpus.voc.Size())
 	}
 	// Check that suffix indices returned by fast search matches those returned by slow search.
 	for _, unigram := range unigrams {
@@ -42,6 +53,1032 @@ func TestBasics(t *testing.T) {
 	}
 }
 
+// The suffix array should match the one obtained by sorting suffixes, including on highly repetitive input.
+func TestSuffixArray(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		// Small alphabets give many repeated substrings.
+		seq := make([]int, rng.Intn(200))
+		alphabet := 1 + rng.Intn(4)
+		for i := range seq {
+			seq[i] = rng.Intn(alphabet)
+		}
+		test_corpus := &Corpus{seq: newIntArray(seq, alphabet)}
+		test_corpus.SetSuffixArray()
+		sorted := make([]int, len(seq))
+		for i := range sorted {
+			sorted[i] = i
+		}
+		sort.Slice(sorted, func(i, j int) bool { return SeqCmp(seq[sorted[i]:], seq[sorted[j]:]) == -1 })
+		if SeqCmp(intsOf(test_corpus.sfx), sorted) != 0 {
+			t.Fatalf("Suffix array of %v is %v, expected %v!", seq, intsOf(test_corpus.sfx), sorted)
+		}
+		// The deprecated sort.Interface methods should agree with the suffix array.
+		if !sort.IsSorted(test_corpus) {
+			t.Fatalf("Suffix array of %v is not sorted according to Less!", seq)
+		}
+		rng.Shuffle(len(seq), test_corpus.Swap)
+		sort.Sort(test_corpus)
+		if SeqCmp(intsOf(test_corpus.sfx), sorted) != 0 {
+			t.Fatalf("Sorting the shuffled suffix array of %v gives %v, expected %v!", seq, intsOf(test_corpus.sfx), sorted)
+		}
+	}
+	// A single type repeated many times should sort quickly, shortest suffix first.
+	repeated := &Corpus{seq: newIntArray(make([]int, 2000000), 0)}
+	repeated.SetSuffixArray()
+	for spos := 0; spos < repeated.sfx.Len(); spos++ {
+		if repeated.sfx.At(spos) != repeated.seq.Len()-1-spos {
+			t.Fatalf("Suffix ordering error in repeated corpus at position %d!", spos)
+		}
+	}
+}
+
+// Each storage layout should hold the same values, and a corpus with packed tokens should give the same results.
+func TestStorage(t *testing.T) {
+	values := []int{0, 5, 1023, 7, 1, 1000, 512}
+	for _, arr := range []intArray{newIntArray(values, 1023), wideArray(values), newPackedArray(wideArray(values), 10), newPackedArray(wideArray(values), 13)} {
+		if SeqCmp(intsOf(arr), values) != 0 {
+			t.Errorf("%T holds %v rather than %v!", arr, intsOf(arr), values)
+		}
+	}
+	packed, _ := NewCorpusFromInts(corpus.Corpus(), corpus.voc)
+	packed.PackTokens()
+	if _, ok := packed.seq.(*packedArray); !ok {
+		t.Fatalf("Corpus tokens were not packed!")
+	}
+	for _, unigram := range corpus.Ngrams(1) {
+		if packed.Frequency(unigram) != corpus.Frequency(unigram) {
+			t.Errorf("Packed corpus gives frequency %d for %v rather than %d!", packed.Frequency(unigram), unigram, corpus.Frequency(unigram))
+		}
+	}
+}
+
+// Returns a vocabulary of the given strings.
+func testVocabulary(strs ...string) *Vocabulary {
+	voc, _ := NewVocabularyFromStrings(strs)
+	return voc
+}
+
+// Returns whether two arrays hold the same values.
+func sameArrays(arr1, arr2 intArray) bool {
+	return SeqCmp(intsOf(arr1), intsOf(arr2)) == 0
+}
+
+// The LCP array should match direct comparison of adjacent suffixes, and give the same ngrams and frequencies.
+func TestLCP(t *testing.T) {
+	enhanced, _ := NewCorpusFromInts(corpus.Corpus(), corpus.voc)
+	enhanced.SetLCPArray()
+	seq, sfx := intsOf(corpus.seq), intsOf(corpus.sfx)
+	for spos := 1; spos < len(sfx); spos++ {
+		length := 0
+		for sfx[spos-1]+length < len(seq) && sfx[spos]+length < len(seq) && seq[sfx[spos-1]+length] == seq[sfx[spos]+length] {
+			length++
+		}
+		if enhanced.LCP(spos) != length || corpus.LCP(spos) != length {
+			t.Fatalf("LCP at suffix position %d is %d, expected %d!", spos, enhanced.LCP(spos), length)
+		}
+	}
+	for order := 1; order <= MAX_NGRAM_LENGTH; order++ {
+		ngrams, enhanced_ngrams := corpus.Ngrams(order), enhanced.Ngrams(order)
+		if len(ngrams) != len(enhanced_ngrams) {
+			t.Fatalf("%d %d-grams found with the LCP array, expected %d!", len(enhanced_ngrams), order, len(ngrams))
+		}
+		for i, ngram := range ngrams {
+			if SeqCmp(ngram, enhanced_ngrams[i]) != 0 || enhanced.Frequency(ngram) != corpus.Frequency(ngram) {
+				t.Errorf("Ngram %v differs with the LCP array!", ngram)
+			}
+		}
+	}
+	// Repeat queries on a small corpus with known answers.
+	small, _ := NewCorpusFromInts([]int{0, 1, 0, 1, 0, 2}, testVocabulary("a", "b", "c"))
+	small.SetLCPArray()
+	if repeat := small.LongestRepeat(); SeqCmp(repeat, []int{0, 1, 0}) != 0 {
+		t.Errorf("Longest repeat is %v, expected [0 1 0]!", repeat)
+	}
+	distinct := make(map[string]bool)
+	small_seq := small.Corpus()
+	for i := 0; i < len(small_seq); i++ {
+		for j := i + 1; j <= len(small_seq); j++ {
+			distinct[fmt.Sprint(small_seq[i:j])] = true
+		}
+	}
+	if small.DistinctSubstrings() != len(distinct) {
+		t.Errorf("%d distinct substrings counted, expected %d!", small.DistinctSubstrings(), len(distinct))
+	}
+}
+
+// Maximal and supermaximal repeats should match those found by brute force.
+func TestRepeats(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for trial := 0; trial < 50; trial++ {
+		seq := make([]int, 2+rng.Intn(60))
+		for i := range seq {
+			seq[i] = rng.Intn(3)
+		}
+		test_corpus, _ := NewCorpusFromInts(seq, testVocabulary("a", "b", "c"))
+		if trial%2 == 0 {
+			test_corpus.SetLCPArray()
+		}
+		// Find the maximal repeats by checking every substring which occurs at least twice.
+		maximal := make(map[string]int)
+		for i := 0; i < len(seq); i++ {
+			for j := i + 1; j <= len(seq); j++ {
+				positions := test_corpus.Find(seq[i:j])
+				if len(positions) < 2 {
+					continue
+				}
+				lefts, rights := make(map[int]bool), make(map[int]bool)
+				for _, cpos := range positions {
+					lefts[-1] = lefts[-1] || cpos == 0
+					rights[-1] = rights[-1] || cpos+j-i == len(seq)
+					if cpos > 0 {
+						lefts[seq[cpos-1]] = true
+					}
+					if cpos+j-i < len(seq) {
+						rights[seq[cpos+j-i]] = true
+					}
+				}
+				if (lefts[-1] || len(lefts) > 2) && (rights[-1] || len(rights) > 2) {
+					maximal[fmt.Sprint(seq[i:j])] = len(positions)
+				}
+			}
+		}
+		// Supermaximal repeats are not contained in any other maximal repeat.
+		supermaximal := make(map[string]int)
+		for key, frequency := range maximal {
+			contained := false
+			for other := range maximal {
+				if other != key && strings.Contains(" "+strings.Trim(other, "[]")+" ", " "+strings.Trim(key, "[]")+" ") {
+					contained = true
+				}
+			}
+			if !contained {
+				supermaximal[key] = frequency
+			}
+		}
+		for supermaximal_only, expected := range map[bool]map[string]int{false: maximal, true: supermaximal} {
+			found := make(map[string]int)
+			for _, repeat := range test_corpus.Repeats(1, 2, supermaximal_only) {
+				found[fmt.Sprint(repeat.Seq)] = repeat.Frequency
+				positions := test_corpus.Find(repeat.Seq)
+				sort.Ints(positions)
+				if SeqCmp(positions, repeat.Positions) != 0 {
+					t.Errorf("Repeat %v has positions %v, expected %v!", repeat.Seq, repeat.Positions, positions)
+				}
+			}
+			if fmt.Sprint(found) != fmt.Sprint(expected) {
+				t.Fatalf("Repeats of %v (supermaximal %v) are %v, expected %v!", seq, supermaximal_only, found, expected)
+			}
+		}
+	}
+}
+
+// EachNgram should yield the same ngrams as Ngrams, with their frequencies, and respect its options.
+func TestEachNgram(t *testing.T) {
+	for order := 1; order <= MAX_NGRAM_LENGTH; order++ {
+		ngrams := corpus.Ngrams(order)
+		i := 0
+		corpus.EachNgram(NgramOptions{MinOrder: order, MinFrequency: 2}, func(ngram []int, frequency, slo, shi int) bool {
+			// Skip the ngrams which occur only once.
+			for i < len(ngrams) && corpus.Frequency(ngrams[i]) < 2 {
+				i++
+			}
+			if i == len(ngrams) || SeqCmp(ngram, ngrams[i]) != 0 || frequency != corpus.Frequency(ngram) || frequency < 2 {
+				t.Fatalf("EachNgram yielded %v with frequency %d out of order!", ngram, frequency)
+			}
+			if slo_search, shi_search := corpus.SuffixSearch(ngram); slo != slo_search || shi != shi_search {
+				t.Errorf("EachNgram yielded range (%d, %d) for %v, expected (%d, %d)!", slo, shi, ngram, slo_search, shi_search)
+			}
+			i++
+			return true
+		})
+	}
+	// Orders should be enumerated in turn, and enumeration should stop when asked.
+	count, last_order := 0, 0
+	corpus.EachNgram(NgramOptions{MinOrder: 1, MaxOrder: 3}, func(ngram []int, frequency, slo, shi int) bool {
+		if len(ngram) < last_order {
+			t.Errorf("EachNgram enumerated a %d-gram after a %d-gram!", len(ngram), last_order)
+		}
+		last_order = len(ngram)
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Errorf("EachNgram did not stop early (%d ngrams enumerated)!", count)
+	}
+}
+
+// The vocabulary should map between strings and integers in both directions.
+func TestVocabulary(t *testing.T) {
+	corpus.voc.Each(func(id int, str string) bool {
+		if found_id, found := corpus.voc.ID(str); !found || found_id != id || corpus.voc.String(id) != str {
+			t.Errorf("Vocabulary does not map between %q and %d!", str, id)
+		}
+		return true
+	})
+	if _, found := corpus.voc.ID("no such type"); found {
+		t.Errorf("Vocabulary contains a type not in the corpus!")
+	}
+	if strs := corpus.Vocabulary().Strings(); len(strs) != corpus.voc.Size() || corpus.ToString([]int{0})[0] != strs[0] {
+		t.Errorf("Vocabulary strings are not in integer order!")
+	}
+	// The corpus's vocabulary should be read-only, so that adding a type cannot change the corpus.
+	view := corpus.Vocabulary()
+	if id, err := view.Add(corpus.voc.String(0)); id != 0 || err != nil || !view.ReadOnly() {
+		t.Errorf("Read-only vocabulary maps %q to %d (%v)!", corpus.voc.String(0), id, err)
+	}
+	if _, err := view.Add("no such type"); err == nil {
+		t.Errorf("Added a type to a read-only vocabulary!")
+	}
+	if _, found := corpus.voc.ID("no such type"); found || corpus.voc.Size() != view.Size() {
+		t.Errorf("Adding to a read-only vocabulary changed the corpus!")
+	}
+	if _, err := NewVocabularyFromMap(map[string]int{"a": 0, "b": 2}); err == nil {
+		t.Errorf("Expected an error for a vocabulary with a gap in its integers!")
+	}
+}
+
+// Text queries should be tokenized like the corpus, and out-of-vocabulary tokens reported.
+func TestTextQueries(t *testing.T) {
+	seq, err := corpus.Parse("W67 w16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frequency, err := corpus.FrequencyOf("W67 w16"); err != nil || frequency != corpus.Frequency(seq) || frequency == 0 {
+		t.Errorf("FrequencyOf gave %d (%v), expected %d!", frequency, err, corpus.Frequency(seq))
+	}
+	if indices, err := corpus.FindOf("w67 w16"); err != nil || len(indices) != corpus.Frequency(seq) {
+		t.Errorf("FindOf gave %v (%v)!", indices, err)
+	}
+	_, err = corpus.FrequencyOf("w67 zzz w16 yyy")
+	if oov, ok := err.(*OOVError); !ok || strings.Join(oov.Tokens, " ") != "zzz yyy" {
+		t.Errorf("Expected an OOVError for zzz and yyy but got %v!", err)
+	}
+	if _, err := corpus.Lookup([]string{"w67", "w16"}); err != nil {
+		t.Errorf("Lookup failed for tokens in the vocabulary: %v", err)
+	}
+	// A phrase of tokens in the vocabulary which does not occur should have no occurrences.
+	small := NewCorpusFromTokens(strings.Fields("a b c a b d"))
+	if indices, err := small.FindOf("c a b d a"); err != nil || len(indices) != 0 {
+		t.Errorf("FindOf gave %v (%v) for an absent phrase!", indices, err)
+	}
+	if cooc, err := small.CoocVectorOf("c a b d a"); err != nil || len(cooc.Keys()) != 0 {
+		t.Errorf("CoocVectorOf gave %v (%v) for an absent phrase!", cooc, err)
+	}
+	absent, _ := small.Lookup(strings.Fields("c a b d a"))
+	small.NearestNeighbors(absent, [][]int{{0}})
+}
+
+// Segment and document boundaries should be recorded, and matches spanning them excluded when asked.
+func TestBoundaries(t *testing.T) {
+	text := "a b c\nd a b\n\nc d\n\n\na b\n"
+	lines, _ := NewCorpusFromReader(strings.NewReader(text), WhitespaceTokenizer{}, LineSegments)
+	paragraphs, _ := NewCorpusFromReader(strings.NewReader(text), WhitespaceTokenizer{}, ParagraphSegments)
+	if lines.NumSegments() != 4 || paragraphs.NumSegments() != 3 || lines.NumDocuments() != 1 {
+		t.Fatalf("Found %d line segments, %d paragraph segments and %d documents, expected 4, 3 and 1!", lines.NumSegments(), paragraphs.NumSegments(), lines.NumDocuments())
+	}
+	if start, end := paragraphs.SegmentRange(1); start != 6 || end != 8 {
+		t.Errorf("Second paragraph occupies [%d, %d), expected [6, 8)!", start, end)
+	}
+	ab, _ := lines.Parse("a b")
+	ca, _ := lines.Parse("c d")
+	if lines.Frequency(ab) != 3 || lines.FrequencyWithin(ab, SegmentBoundary) != 3 {
+		t.Errorf("Expected 3 occurrences of 'a b' within lines!")
+	}
+	if lines.Frequency(ca) != 2 || lines.FrequencyWithin(ca, SegmentBoundary) != 1 || len(lines.FindWithin(ca, SegmentBoundary)) != 1 {
+		t.Errorf("Expected 1 of 2 occurrences of 'c d' within lines!")
+	}
+	if paragraphs.FrequencyWithin(ca, SegmentBoundary) != 2 || paragraphs.FrequencyWithin(ca, DocumentBoundary) != 2 {
+		t.Errorf("Expected 2 occurrences of 'c d' within paragraphs!")
+	}
+	// Ngram enumeration should count only the occurrences within segments.
+	bigrams := 0
+	lines.EachNgram(NgramOptions{MinOrder: 2, Within: SegmentBoundary}, func(ngram []int, frequency, slo, shi int) bool {
+		if frequency != lines.FrequencyWithin(ngram, SegmentBoundary) {
+			t.Errorf("EachNgram counted %d occurrences of %v within segments!", frequency, ngram)
+		}
+		bigrams++
+		return true
+	})
+	if bigrams != 4 {
+		t.Errorf("EachNgram found %d bigrams within segments, expected 4!", bigrams)
+	}
+	// Positions should map back to their documents.
+	if doc, offset := lines.Locate(5); doc != 0 || offset != 5 || lines.SegmentOf(5) != 1 {
+		t.Errorf("Position 5 located in document %d at offset %d in segment %d!", doc, offset, lines.SegmentOf(5))
+	}
+}
+
+// Corpora built from several files should record each file as a document with its metadata.
+func TestDocuments(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": "the cat sat\n", "b.txt": "the dog ran\nthe cat\n", "sub/c.txt": "cat the\n", ".hidden": "cat cat\n"}
+	for name, text := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from_dir, err := NewCorpusFromDir(dir, WhitespaceTokenizer{}, LineSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from_dir.NumDocuments() != 3 || from_dir.DocumentName(2) != filepath.Join(dir, "sub/c.txt") {
+		t.Fatalf("Directory corpus has %d documents, the last named %q!", from_dir.NumDocuments(), from_dir.DocumentName(from_dir.NumDocuments()-1))
+	}
+	from_glob, err := NewCorpusFromGlob(filepath.Join(dir, "*.txt"), WhitespaceTokenizer{}, LineSegments)
+	if err != nil || from_glob.NumDocuments() != 2 {
+		t.Errorf("Glob corpus has %d documents (%v), expected 2!", from_glob.NumDocuments(), err)
+	}
+	if _, err := NewCorpusFromGlob(filepath.Join(dir, "*.none"), WhitespaceTokenizer{}, LineSegments); err == nil {
+		t.Errorf("Expected an error for a pattern matching no files!")
+	}
+	// Matches should resolve to documents and offsets.
+	the_cat, _ := from_dir.Parse("the cat")
+	locations := from_dir.FindLocations(the_cat)
+	if fmt.Sprint(locations) != "[{0 0} {1 3}]" {
+		t.Errorf("Found 'the cat' at %v!", locations)
+	}
+	// Metadata should be read from JSON and CSV sidecars, matching documents by their base names.
+	json_sidecar, csv_sidecar := filepath.Join(t.TempDir(), "meta.json"), filepath.Join(t.TempDir(), "meta.csv")
+	os.WriteFile(json_sidecar, []byte(`{"a.txt": {"genre": "news", "year": 2001}, "c.txt": {"genre": "news"}}`), 0644)
+	os.WriteFile(csv_sidecar, []byte("filename,genre,author\nb.txt,fiction,Smith\nmissing.txt,news,Jones\n"), 0644)
+	for _, sidecar := range []string{json_sidecar, csv_sidecar} {
+		if err := from_dir.ReadMetadata(sidecar); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fields := from_dir.Metadata(0); fields["genre"] != "news" || fields["year"] != "2001" || from_dir.Metadata(1)["author"] != "Smith" {
+		t.Errorf("Unexpected metadata %v and %v!", fields, from_dir.Metadata(1))
+	}
+	// Subcorpora should count only occurrences within their documents.
+	news := from_dir.SubcorpusWhere("genre", "news")
+	cat, _ := from_dir.Parse("cat")
+	if fmt.Sprint(news.Documents()) != "[0 2]" || news.Len() != 5 || news.Frequency(cat) != 2 || news.Frequency(the_cat) != 1 {
+		t.Errorf("News subcorpus has documents %v, %d tokens and frequencies %d and %d!", news.Documents(), news.Len(), news.Frequency(cat), news.Frequency(the_cat))
+	}
+	// Bigrams can start at two positions of the first news document and one of the second.
+	if news.Probability(the_cat) != 1.0/3.0 || from_dir.Subcorpus(func(map[string]string) bool { return true }).Probability(the_cat) != 2.0/7.0 {
+		t.Errorf("News subcorpus gives 'the cat' probability %g!", news.Probability(the_cat))
+	}
+	// A match spanning two documents should not be found.
+	cat_cat, _ := from_dir.Parse("cat cat")
+	if from_dir.Frequency(cat_cat) != 1 || from_dir.FrequencyWithin(cat_cat, DocumentBoundary) != 0 {
+		t.Errorf("Expected no occurrences of 'cat cat' within documents!")
+	}
+}
+
+// Dispersion measures should match values computed by hand.
+func TestDispersion(t *testing.T) {
+	tokens := strings.Fields("a x a x a x x x x x")
+	parts := NewCorpusFromTokens(tokens)
+	d := parts.PartDispersion([]int{0}, 5)
+	if d.Frequency != 3 || d.Parts != 5 || d.Range != 3 || math.Abs(d.GriesDP-0.4) > 1e-9 || math.Abs(d.DPNorm-0.5) > 1e-9 || math.Abs(d.JuillandD-(1-math.Sqrt(0.06)/0.3/2)) > 1e-9 {
+		t.Errorf("Unexpected dispersion over parts %+v!", d)
+	}
+	// Dispersion over documents should use their sizes, and ignore occurrences spanning two documents.
+	builder := newCorpusBuilder()
+	for _, doc := range []string{"a x a", "x a x x", "x x x"} {
+		builder.startDocument(nil)
+		for _, token := range strings.Fields(doc) {
+			builder.add(token)
+		}
+	}
+	docs := builder.corpus()
+	d = docs.DocumentDispersion([]int{0})
+	if d.Frequency != 3 || d.Parts != 3 || d.Range != 2 || math.Abs(d.GriesDP-11.0/30) > 1e-9 || docs.DocumentFrequency([]int{0}) != 2 {
+		t.Errorf("Unexpected dispersion over documents %+v!", d)
+	}
+	if docs.DocumentFrequency([]int{0, 1, 0}) != 1 || docs.DocumentFrequency([]int{0, 1}) != 2 || docs.DocumentFrequency([]int{1, 1, 1, 1}) != 0 {
+		t.Errorf("Unexpected document frequencies!")
+	}
+}
+
+// Concordance lines should show the context of each occurrence, in the requested order.
+func TestConcordance(t *testing.T) {
+	kwic, _ := NewCorpusFromReader(strings.NewReader("b the cat sat\nc the dog\na the cat ran\n"), WhitespaceTokenizer{}, LineSegments)
+	the, _ := kwic.Parse("the")
+	keywords := func(lines []ConcordanceLine) (result []string) {
+		for _, line := range lines {
+			result = append(result, strings.Join(line.Left, " ")+"|"+strings.Join(line.Right, " "))
+		}
+		return
+	}
+	for _, test := range []struct {
+		options  ConcordanceOptions
+		expected string
+	}{
+		{ConcordanceOptions{Left: 1, Right: 2}, "[b|cat sat c|dog a a|cat ran]"},
+		{ConcordanceOptions{Left: 1, Right: 2, Within: SegmentBoundary}, "[b|cat sat c|dog a|cat ran]"},
+		{ConcordanceOptions{Left: 1, Right: 1, Sort: SortRight}, "[b|cat a|cat c|dog]"},
+		{ConcordanceOptions{Left: 1, Right: 1, Sort: SortLeft}, "[a|cat b|cat c|dog]"},
+		{ConcordanceOptions{Left: 2, Right: 2, Sort: SortRight}, "[dog a|cat ran b|cat sat sat c|dog a]"},
+	} {
+		if lines := kwic.Concordance(the, test.options); fmt.Sprint(keywords(lines)) != test.expected {
+			t.Errorf("Concordance with options %+v gave %v, expected %s!", test.options, keywords(lines), test.expected)
+		}
+	}
+	// Negative widths should give empty contexts, which are written as empty JSON arrays.
+	var empty strings.Builder
+	WriteConcordance(&empty, kwic.Concordance(the, ConcordanceOptions{Left: -1, Right: -2}), JSONFormat)
+	if !strings.Contains(empty.String(), `"left": []`) || !strings.Contains(empty.String(), `"right": []`) {
+		t.Errorf("Unexpected output for negative widths:\n%s", empty.String())
+	}
+	// Sampling should be repeatable for a given seed.
+	sample := kwic.Concordance(the, ConcordanceOptions{Sample: 2, Seed: 1})
+	if len(sample) != 2 || sample[0].Position > sample[1].Position || fmt.Sprint(sample) != fmt.Sprint(kwic.Concordance(the, ConcordanceOptions{Sample: 2, Seed: 1})) {
+		t.Errorf("Unexpected sample %v!", sample)
+	}
+	// Each format should write every line.
+	lines := kwic.Concordance(the, ConcordanceOptions{Left: 1, Right: 1})
+	var text, tsv, js strings.Builder
+	WriteConcordance(&text, lines, TextFormat)
+	WriteConcordance(&tsv, lines, TSVFormat)
+	WriteConcordance(&js, lines, JSONFormat)
+	if !strings.HasPrefix(text.String(), "b  the  cat\n") || !strings.Contains(tsv.String(), "\n5\t0\tc\tthe\tdog\n") || !strings.Contains(js.String(), `"position": 8`) {
+		t.Errorf("Unexpected output:\n%s\n%s\n%s", text.String(), tsv.String(), js.String())
+	}
+}
+
+// Patterns with wildcards, gaps and alternatives should match as documented.
+func TestPatterns(t *testing.T) {
+	text := "take into account the state of the art\ntake it into account\na house and an old house and the house\n"
+	patterns, _ := NewCorpusFromReader(strings.NewReader(text), WhitespaceTokenizer{}, LineSegments)
+	for _, test := range []struct {
+		pattern  string
+		within   Boundary
+		expected string
+	}{
+		{"the * of", NoBoundary, "[the state of]"},
+		{"take {0,3} account", NoBoundary, "[take into account take it into account]"},
+		{"(a|an|the|some) house", NoBoundary, "[a house the house]"},
+		{"(a|an|the) {0,1} house", NoBoundary, "[a house an old house the house]"},
+		{"account {1,2}", NoBoundary, "[account the account the state account a account a house]"},
+		{"account {1,2}", SegmentBoundary, "[account the account the state]"},
+		{"{1} into", NoBoundary, "[take into it into]"},
+	} {
+		matches, err := patterns.FindPattern(test.pattern, test.within)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make([]string, len(matches))
+		for i, match := range matches {
+			found[i] = strings.Join(patterns.ToString(sliceOf(patterns.seq, match.Position, match.Position+match.Length)), " ")
+		}
+		if fmt.Sprint(found) != test.expected {
+			t.Errorf("Pattern %q matched %v, expected %s!", test.pattern, found, test.expected)
+		}
+	}
+	ngrams, _ := patterns.PatternNgrams("* (and|of)", NoBoundary)
+	if len(ngrams) != 2 || ngrams[0].Frequency != 2 || strings.Join(patterns.ToString(ngrams[0].Seq), " ") != "house and" {
+		t.Errorf("Unexpected ngrams %v!", ngrams)
+	}
+	for _, bad := range []string{"", "take {3,1} account", "take {a} account", "take {1,2,3} account", "* {0,3} *"} {
+		if _, err := patterns.FindPattern(bad, NoBoundary); err == nil {
+			t.Errorf("Expected an error for pattern %q!", bad)
+		}
+	}
+	if _, err := patterns.FindPattern("the (cow|pig)", NoBoundary); err == nil {
+		t.Errorf("Expected an OOVError!")
+	}
+}
+
+// CQL queries should match tokens by their attributes.
+func TestQueries(t *testing.T) {
+	vertical := "<s>\nThe\tthe\tDT\ndog\tdog\tNN\nruns\trun\tVBZ\nhome\thome\tNN\n</s>\n\n" +
+		"<s>\nShe\tshe\tPRP\nran\trun\tVBD\nthe\tthe\tDT\nrace\trace\tNN\n</s>\n\n" +
+		"<s>\nRunning\trun\tVBG\ndogs\tdog\tNNS\nrun\trun\tVBP\nfast\tfast\tRB\n</s>\n"
+	annotated, err := NewCorpusFromVerticalReader(strings.NewReader(vertical), []string{"lemma", "pos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(annotated.Attributes()) != "[word lemma pos]" || annotated.Attribute("pos", 1) != "NN" || annotated.NumSegments() != 3 {
+		t.Fatalf("Unexpected attributes %v and %d segments!", annotated.Attributes(), annotated.NumSegments())
+	}
+	for _, test := range []struct {
+		query    string
+		within   Boundary
+		expected string
+	}{
+		{`[lemma="run" & pos="V.*"] [pos="DT"]? [pos="N.*"]`, NoBoundary, "[runs home ran the race Running dogs]"},
+		{`[word="run.*"%c]`, NoBoundary, "[runs Running run]"},
+		{`"the"`, NoBoundary, "[the]"},
+		{`[pos="DT"] []`, NoBoundary, "[The dog the race]"},
+		{`[pos="NN.*"] [pos!="NN.*" & !(lemma="run")]`, NoBoundary, "[home She]"},
+		{`[pos="NN.*"] [pos!="NN.*" & !(lemma="run")]`, SegmentBoundary, "[]"},
+		{`[pos="PRP"] []{1,2} [pos="NN"]`, NoBoundary, "[She ran the race]"},
+		{`[lemma="dog"] [pos="V.*" | word="fast"]+`, SegmentBoundary, "[dog runs dogs run dogs run fast]"},
+	} {
+		matches, err := annotated.FindQuery(test.query, test.within)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make([]string, len(matches))
+		for i, match := range matches {
+			found[i] = strings.Join(annotated.ToString(sliceOf(annotated.seq, match.Position, match.Position+match.Length)), " ")
+		}
+		if fmt.Sprint(found) != test.expected {
+			t.Errorf("Query %s matched %v, expected %s!", test.query, found, test.expected)
+		}
+	}
+	for _, bad := range []string{"", `[pos="NN"`, `[tag="NN"]`, `[pos="("]`, `[pos="NN"]{2,1}`, `pos="NN"`} {
+		if _, err := annotated.FindQuery(bad, NoBoundary); err == nil {
+			t.Errorf("Expected an error for query %s!", bad)
+		}
+	}
+	// Attribute layers should be saved and loaded.
+	var buf bytes.Buffer
+	annotated.Save(&buf)
+	loaded, err := LoadCorpus(&buf)
+	if err != nil || loaded.Attribute("lemma", 8) != "run" {
+		t.Errorf("Attributes were not saved and loaded (%v)!", err)
+	}
+}
+
+// Annotated input should be read with its boundaries, attributes and metadata, and malformed lines reported.
+func TestFormats(t *testing.T) {
+	conllu := "# newdoc id = d1\n# sent_id = 1\n# text = Don't go.\n" +
+		"1-2\tDon't\t_\t_\t_\t_\t_\t_\t_\t_\n1\tDo\tdo\tAUX\tVBP\t_\t3\taux\t_\t_\n2\tn't\tnot\tPART\tRB\t_\t3\tadvmod\t_\t_\n" +
+		"3\tgo\tgo\tVERB\tVB\t_\t0\troot\t_\tSpaceAfter=No\n4\t.\t.\tPUNCT\t.\t_\t3\tpunct\t_\t_\n\n" +
+		"# newdoc id = d2\n1\tGo\tgo\tVERB\tVB\t_\t0\troot\t_\t_\n1.1\tgo\tgo\tVERB\tVB\t_\t_\t_\t0:root\t_\n\n"
+	from_conllu, err := NewCorpusFromCoNLLUReader(strings.NewReader(conllu))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from_conllu.Len() != 5 || from_conllu.NumDocuments() != 2 || from_conllu.NumSegments() != 2 || from_conllu.Metadata(1)["id"] != "d2" {
+		t.Errorf("CoNLL-U corpus has %d tokens, %d documents and %d segments!", from_conllu.Len(), from_conllu.NumDocuments(), from_conllu.NumSegments())
+	}
+	if from_conllu.Attribute("lemma", 1) != "not" || from_conllu.Attribute("deprel", 2) != "root" || from_conllu.Attribute("misc", 2) != "SpaceAfter=No" {
+		t.Errorf("CoNLL-U attributes were not read!")
+	}
+	vertical := "<doc id=\"a\" genre=\"news\">\n<s>\nDogs\tNNS\nbark\tVBP\n</s>\n<s>\nLoudly\tRB\n</s>\n</doc>\n<doc id=\"b\">\n<p>\nCats\tNNS\n</p>\n</doc>\n"
+	from_vertical, err := NewCorpusFromVerticalReader(strings.NewReader(vertical), []string{"pos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from_vertical.NumDocuments() != 2 || from_vertical.NumSegments() != 3 || from_vertical.Metadata(0)["genre"] != "news" || from_vertical.SubcorpusWhere("id", "b").Len() != 1 {
+		t.Errorf("Vertical corpus has %d documents and %d segments!", from_vertical.NumDocuments(), from_vertical.NumSegments())
+	}
+	from_tsv, err := NewCorpusFromTSVReader(strings.NewReader("word\tlemma\tpos\nDogs\tdog\tNNS\nbark\tbark\tVBP\n\nCats\tcat\tNNS\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(from_tsv.Attributes()) != "[word lemma pos]" || from_tsv.NumSegments() != 2 || from_tsv.Attribute("lemma", 2) != "cat" {
+		t.Errorf("TSV corpus has attributes %v and %d segments!", from_tsv.Attributes(), from_tsv.NumSegments())
+	}
+	// Compressed input should be decompressed.
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(conllu))
+	zw.Close()
+	if from_gzip, err := NewCorpusFromCoNLLUReader(&gz); err != nil || from_gzip.Len() != from_conllu.Len() {
+		t.Errorf("Compressed CoNLL-U was not read (%v)!", err)
+	}
+	// Malformed lines should be reported with their line numbers.
+	for _, test := range []struct {
+		format string
+		input  string
+		line   int
+	}{
+		{"conllu", "1\tDo\tdo\n", 1},
+		{"conllu", "# c\nx\tDo\t_\t_\t_\t_\t_\t_\t_\t_\n", 2},
+		{"vertical", "<s>\nDogs\tNNS\nbark\n", 3},
+		{"tsv", "word\tpos\nDogs\tNNS\tx\n", 2},
+	} {
+		var err error
+		switch test.format {
+		case "conllu":
+			_, err = NewCorpusFromCoNLLUReader(strings.NewReader(test.input))
+		case "vertical":
+			_, err = NewCorpusFromVerticalReader(strings.NewReader(test.input), []string{"pos"})
+		case "tsv":
+			_, err = NewCorpusFromTSVReader(strings.NewReader(test.input))
+		}
+		if e, ok := err.(*FormatError); !ok || e.Line != test.line {
+			t.Errorf("Expected a FormatError on line %d of %s input but got %v!", test.line, test.format, err)
+		}
+	}
+	// Reading should stop at the first malformed line, before the input fails.
+	failing := io.MultiReader(strings.NewReader("1\tDo\tdo\n"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := NewCorpusFromCoNLLUReader(failing); !errors.As(err, new(*FormatError)) {
+		t.Errorf("Expected a FormatError before the read error but got %v!", err)
+	}
+}
+
+// Reading errors should be returned rather than exiting, and long lines should be read whole.
+func TestReadErrors(t *testing.T) {
+	// A missing file should be reported as such.
+	_, err := NewCorpusFromFile(strings.Join([]string{path, "/data/no_such_file.txt"}, ""), WhitespaceTokenizer{}, NoSegments)
+	if _, ok := err.(*FileNotFoundError); !ok {
+		t.Errorf("Expected a FileNotFoundError for a missing file but got %v!", err)
+	}
+	// A line much longer than the read buffer should be tokenized in full.
+	filename := strings.Join([]string{t.TempDir(), "/long.txt"}, "")
+	long_line := strings.Repeat("w1 w2 ", 20000)
+	if err := os.WriteFile(filename, []byte("w0\n"+long_line+"\nw3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := ReadTokensFromFile(filename, ClassicTokenizer{LowerCase: true})
+	if err != nil {
+		t.Fatalf("Unexpected error reading long line: %v", err)
+	}
+	expected := 0
+	for _, line := range []string{"w0", long_line, "w3"} {
+		expected += len(TokenizeLine(line, true, false))
+	}
+	if len(tokens) != expected {
+		t.Errorf("Long line was not tokenized in full (%d tokens)!", len(tokens))
+	}
+	// A line longer than the configured maximum should be reported with its line number.
+	limited := CorpusReader{Tokenizer: ClassicTokenizer{LowerCase: true}, MaxLineLength: 1000}
+	_, err = limited.ReadTokensFromFile(filename)
+	if e, ok := err.(*LineTooLongError); !ok || e.Line != 2 || e.Max != 1000 {
+		t.Errorf("Expected a LineTooLongError on line 2 but got %v!", err)
+	}
+	if _, err = limited.ReadFile(filename); err == nil {
+		t.Errorf("Expected a LineTooLongError reading a corpus!")
+	}
+}
+
+// Compressed input files should be detected and decompressed as they are read.
+func TestCompressedInput(t *testing.T) {
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("the cat sat\nthe dog\n"))
+	zw.Close()
+	// bzip2 cannot be written with the standard library, so this is "the cat sat\nthe dog\n" compressed beforehand.
+	bz := "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x28\xa9\x0a\xe0\x00\x00\x09\x51\x80\x00\x10\x40\x00\x2e\xc0\x8c\x00\x20\x00\x31\x00\xd3\x4d\x02\x54\x0c\x4d\xea\x4b\x46\xda\x92\xc6\x07\xc8\x98\x2e\xe4\x8a\x70\xa1\x20\x51\x52\x15\xc0"
+	for name, data := range map[string][]byte{"text.gz": gz.Bytes(), "text.bz2": []byte(bz), "text": []byte("the cat sat\nthe dog\n")} {
+		filename := filepath.Join(dir, name)
+		os.WriteFile(filename, data, 0644)
+		compressed, err := NewCorpusFromFile(filename, WhitespaceTokenizer{}, LineSegments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if compressed.Len() != 5 || compressed.NumSegments() != 2 || strings.Join(compressed.ToString(compressed.Corpus()), " ") != "the cat sat the dog" {
+			t.Errorf("Corpus read from %s has tokens %v!", name, compressed.ToString(compressed.Corpus()))
+		}
+		from_reader, err := NewCorpusFromReader(bytes.NewReader(data), WhitespaceTokenizer{}, LineSegments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from_reader.Len() != 5 {
+			t.Errorf("Corpus read from the contents of %s has tokens %v!", name, from_reader.ToString(from_reader.Corpus()))
+		}
+	}
+	// Text which begins like the bzip2 magic bytes should be read as text.
+	if bzh, err := NewCorpusFromReader(strings.NewReader("BZh the cat\n"), WhitespaceTokenizer{}, NoSegments); err != nil || bzh.Len() != 3 {
+		t.Errorf("Text beginning BZh was not read as text (%v)!", err)
+	}
+	// Zstandard is recognized but not supported.
+	zst := filepath.Join(dir, "text.zst")
+	os.WriteFile(zst, []byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0}, 0644)
+	if _, err := NewCorpusFromFile(zst, WhitespaceTokenizer{}, NoSegments); err == nil {
+		t.Errorf("Expected a CompressionError for zstd input!")
+	} else if _, ok := err.(*CompressionError); !ok {
+		t.Errorf("Expected a CompressionError for zstd input but got %v!", err)
+	}
+}
+
+// Kneser-Ney probabilities should be normalized in every context, and nonzero for novel ngrams.
+func TestKneserNey(t *testing.T) {
+	lm, err := NewKneserNey(corpus, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for order := 1; order <= 3; order++ {
+		for i, d := range lm.Discounts(order) {
+			if d <= 0.0 || d > float64(i+1) {
+				t.Errorf("Discount %d of order %d is %f!", i+1, order, d)
+			}
+		}
+	}
+	seq := intsOf(corpus.seq)
+	for _, context := range [][]int{{}, seq[10:11], seq[10:12], seq[20:23], {seq[10], seq[30]}} {
+		total := 0.0
+		for word := 0; word < corpus.voc.Size(); word++ {
+			total += math.Exp2(lm.LogProb(context, word))
+		}
+		if math.Abs(total-1.0) > 1e-9 {
+			t.Errorf("Probabilities following %v sum to %f!", context, total)
+		}
+	}
+	// A reversed passage contains ngrams which do not occur in the corpus.
+	novel := make([]int, 50)
+	for i := range novel {
+		novel[i] = seq[100-i]
+	}
+	if score := lm.Score(novel); math.IsInf(score, 0) || math.IsNaN(score) || score >= 0.0 {
+		t.Errorf("Unexpected score %f for a novel sequence!", score)
+	}
+	if lm.Score(seq[100:150]) <= lm.Score(novel) {
+		t.Errorf("A passage of the corpus should score higher than a novel sequence!")
+	}
+	// Contexts should be cached under distinct keys.
+	if seqKey([]int{1, 300}) == seqKey([]int{1, 3, 0}) || seqKey([]int{}) == seqKey([]int{0}) {
+		t.Errorf("Distinct contexts share a cache key!")
+	}
+	if _, err := NewKneserNey(corpus, 0); err == nil {
+		t.Errorf("Expected an error for order 0!")
+	}
+}
+
+// The ∞-gram model should use the longest suffix of each context which occurs in the corpus.
+func TestInfiniGram(t *testing.T) {
+	lm := NewInfiniGram(corpus)
+	seq := intsOf(corpus.seq)
+	for i, length := range lm.ContextLengths(seq[100:150]) {
+		if length != i {
+			t.Errorf("Context length %d at position %d of a passage of the corpus!", length, i)
+		}
+	}
+	novel := make([]int, 50)
+	for i := range novel {
+		novel[i] = seq[(i*7919)%len(seq)]
+	}
+	lengths, score := lm.ContextLengths(novel), 0.0
+	for i := range novel {
+		// Compare the effective context length with the longest suffix found by a linear search.
+		expected := 0
+		for length := 1; length <= i; length++ {
+			suffix := novel[i-length : i]
+			for cpos := 0; cpos+length < len(seq); cpos++ {
+				if SeqCmp(seq[cpos:cpos+length], suffix) == 0 {
+					expected = length
+					break
+				}
+			}
+		}
+		if lengths[i] != expected || lm.ContextLength(novel[:i]) != expected {
+			t.Errorf("Context length %d at position %d, expected %d!", lengths[i], i, expected)
+		}
+		probs, length := lm.Distribution(novel[:i])
+		total := 0.0
+		for _, p := range probs {
+			total += p
+		}
+		if length != expected || math.Abs(total-1.0) > 1e-9 {
+			t.Errorf("Distribution at position %d has context length %d and sums to %f!", i, length, total)
+		}
+	}
+	passage := seq[200:300]
+	for i := range passage {
+		score += lm.LogProb(passage[:i], passage[i])
+	}
+	if math.IsInf(score, 0) || math.Abs(score-lm.Score(passage)) > 1e-9 {
+		t.Errorf("Score %f does not match the sum of log probabilities %f!", lm.Score(passage), score)
+	}
+}
+
+// Models should be evaluated on held-out text, with unknown tokens skipped or mapped to <unk>.
+func TestEvaluation(t *testing.T) {
+	training := NewCorpusFromTokens(strings.Fields("the cat sat on the mat . the dog sat on the cat . <unk> sat on the dog ."))
+	held_out := "the cat sat on the dog .\nthe bird sat on the mat .\n\nthe mat sat ."
+	kn, _ := NewKneserNey(training, 3)
+	mle, _ := NewMLE(training, 2)
+	add_one, _ := NewAddK(training, 2, 1.0)
+	backoff, _ := NewStupidBackoff(training, 3, DefaultStupidBackoffAlpha)
+	for _, lm := range []LanguageModel{kn, mle, add_one} {
+		for _, context := range [][]int{{}, {0}, {0, 1}} {
+			total := 0.0
+			for word := 0; word < training.voc.Size(); word++ {
+				total += math.Exp2(lm.LogProb(context, word))
+			}
+			if math.Abs(total-1.0) > 1e-9 {
+				t.Errorf("Probabilities of %T following %v sum to %f!", lm, context, total)
+			}
+		}
+	}
+	for _, test := range []struct {
+		lm     LanguageModel
+		policy UnknownPolicy
+		finite bool
+	}{{kn, SkipUnknown, true}, {kn, MapUnknown, true}, {add_one, MapUnknown, true}, {backoff, SkipUnknown, true}, {mle, SkipUnknown, false}} {
+		eval, err := Evaluate(test.lm, strings.NewReader(held_out), EvaluationOptions{Segmentation: LineSegments, Unknown: test.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eval.Tokens != 18 || eval.OOVs != 1 || len(eval.Sentences) != 3 || eval.Sentences[1].OOVs != 1 || math.Abs(eval.OOVRate-1.0/18.0) > 1e-9 {
+			t.Errorf("Unexpected evaluation %+v!", eval)
+		}
+		if scored := 18 - map[UnknownPolicy]int{SkipUnknown: 1, MapUnknown: 0}[test.policy]; eval.Scored != scored {
+			t.Errorf("%d tokens were scored, expected %d!", eval.Scored, scored)
+		}
+		total := 0.0
+		for _, sentence := range eval.Sentences {
+			total += sentence.LogProb
+		}
+		if total != eval.LogProb || (test.finite && math.Abs(math.Exp2(-eval.LogProb/float64(eval.Scored))-eval.Perplexity) > 1e-9) {
+			t.Errorf("Inconsistent evaluation %+v!", eval)
+		}
+		if math.IsInf(eval.CrossEntropy, 0) == test.finite {
+			t.Errorf("Cross-entropy of %T is %f!", test.lm, eval.CrossEntropy)
+		}
+	}
+	// The first sentence is scored in full, and the second in two parts around the unknown token.
+	eval, _ := Evaluate(kn, strings.NewReader(held_out), EvaluationOptions{Segmentation: LineSegments})
+	first, _ := training.Parse("the cat sat on the dog .")
+	second, _ := training.Parse("sat on the mat .")
+	if eval.Sentences[0].LogProb != kn.Score(first) || math.Abs(eval.Sentences[1].LogProb-kn.Score(first[:1])-kn.Score(second)) > 1e-9 {
+		t.Errorf("Unexpected sentence scores %+v!", eval.Sentences)
+	}
+	if _, err := Evaluate(NewInfiniGram(corpus), strings.NewReader(held_out), EvaluationOptions{Unknown: MapUnknown}); err == nil {
+		t.Errorf("Expected an error for a vocabulary without %s!", UnknownToken)
+	}
+	// Held-out text should be tokenized like the model's corpus unless a tokenizer is given.
+	lower, _ := NewCorpusFromReader(strings.NewReader("the cat sat\n"), LowerCase(WhitespaceTokenizer{}), LineSegments)
+	lower_mle, _ := NewMLE(lower, 1)
+	if eval, err := Evaluate(lower_mle, strings.NewReader("The CAT sat"), EvaluationOptions{}); err != nil || eval.OOVs != 0 {
+		t.Errorf("Held-out text was not lower cased (%+v, %v)!", eval, err)
+	}
+	var saved bytes.Buffer
+	lower.Save(&saved)
+	loaded, _ := LoadCorpus(&saved)
+	loaded_mle, _ := NewMLE(loaded, 1)
+	if _, err := Evaluate(loaded_mle, strings.NewReader("the cat"), EvaluationOptions{}); err == nil {
+		t.Errorf("Expected an error for a model without a tokenizer!")
+	}
+	if eval, err := Evaluate(loaded_mle, strings.NewReader("the cat"), EvaluationOptions{Tokenizer: WhitespaceTokenizer{}}); err != nil || eval.Tokens != 2 {
+		t.Errorf("Unexpected evaluation %+v (%v)!", eval, err)
+	}
+}
+
+// A Kneser-Ney model written in ARPA format should be read back with the same probabilities.
+func TestARPA(t *testing.T) {
+	words, err := NewCorpusFromFile(path+"/data/test_corpus.txt", WhitespaceTokenizer{}, NoSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kn, _ := NewKneserNey(words, 3)
+	seq := intsOf(words.seq)
+	contexts := [][]int{{}, seq[10:11], seq[10:12], seq[20:23], {seq[10], seq[30]}, {seq[40], seq[41], seq[99]}}
+	for _, min_frequencies := range [][]int{nil, {1, 2, 3}} {
+		var buf bytes.Buffer
+		if err := kn.WriteARPA(&buf, min_frequencies); err != nil {
+			t.Fatal(err)
+		}
+		lm, err := ReadARPA(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The unigrams <unk>, <s> and </s> are added to the vocabulary.
+		if lm.Order() != 3 || lm.Vocabulary().Size() != words.voc.Size()+3 {
+			t.Fatalf("Read a model of order %d with %d unigrams!", lm.Order(), lm.Vocabulary().Size())
+		}
+		for _, context := range contexts {
+			total := 0.0
+			for word := 0; word < words.voc.Size(); word++ {
+				// The vocabulary is read in the order it was written, so the integers are the same.
+				log_prob := lm.LogProb(context, word)
+				if min_frequencies == nil && math.Abs(log_prob-kn.LogProb(context, word)) > 1e-5 {
+					t.Errorf("Log probability of %d following %v is %f, expected %f!", word, context, log_prob, kn.LogProb(context, word))
+				}
+				total += math.Exp2(log_prob)
+			}
+			if math.Abs(total-1.0) > 1e-4 {
+				t.Errorf("Probabilities following %v sum to %f with minimum frequencies %v!", context, total, min_frequencies)
+			}
+		}
+	}
+	// The classic tokenizer returns empty tokens, which are written as <unk>.
+	classic, _ := NewKneserNey(corpus, 2)
+	var buf bytes.Buffer
+	if err := classic.WriteARPA(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	lm, err := ReadARPA(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	voc := lm.Vocabulary()
+	unknown, _ := voc.ID(UnknownToken)
+	if _, found := voc.ID("</s>"); !found || unknown == 0 || math.Abs(lm.LogProb(nil, unknown)-classic.LogProb(nil, corpus.voc.ids[""])) > 1e-5 {
+		t.Errorf("Empty tokens were not written as %s!", UnknownToken)
+	}
+	word, _ := voc.ID("w67")
+	for _, context := range [][]int{{}, {word}, {unknown}} {
+		total := 0.0
+		for type_int := 0; type_int < voc.Size(); type_int++ {
+			total += math.Exp2(lm.LogProb(context, type_int))
+		}
+		if math.Abs(total-1.0) > 1e-4 {
+			t.Errorf("Probabilities following %v sum to %f with empty tokens!", context, total)
+		}
+	}
+	for _, bad := range []string{
+		"\\data\\\nngram 1=1\n\n\\1-grams:\n-1.0\n\\end\\\n",
+		"\\data\\\nngram 1=2\n\n\\1-grams:\n-1.0\ta\n\\end\\\n",
+		"\\data\\\nngram 1=1\nngram 2=1\n\n\\1-grams:\n-1.0\ta\t0\n\\2-grams:\n-1.0\ta b\n\\end\\\n",
+		"\\data\\\nngram 1=1\n\n\\1-grams:\n-1.0\ta\n",
+	} {
+		if _, err := ReadARPA(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected an error reading %q!", bad)
+		}
+	}
+}
+
+// Generated text should be reproducible and follow the ngrams of the corpus.
+func TestGenerate(t *testing.T) {
+	words, err := NewCorpusFromFile(path+"/data/test_corpus.txt", WhitespaceTokenizer{}, NoSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq := intsOf(words.seq)
+	options := GenerateOptions{Prompt: "w67", MaxLength: 50, MaxContext: 2, Seed: 7}
+	generated, err := words.Generate(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := words.Generate(options)
+	if len(generated) != 50 || fmt.Sprint(generated) != fmt.Sprint(again) {
+		t.Errorf("Generated %d tokens, which were not reproduced!", len(generated))
+	}
+	for _, options := range []GenerateOptions{options, {MaxLength: 50, MaxContext: 1, Temperature: 0.5, TopK: 5, TopP: 0.9, Seed: 3}} {
+		generated, _ := words.Generate(options)
+		prompt, _ := words.Lookup(strings.Fields(options.Prompt))
+		text := append(prompt, generated...)
+		for i := 1; i < len(text); i++ {
+			if words.followedFrequency(text[i-1:i]) > 0 && words.Frequency(text[i-1:i+1]) == 0 {
+				t.Errorf("Generated bigram %v does not occur in the corpus!", words.ToString(text[i-1:i+1]))
+			}
+		}
+	}
+	// A prompt occurring once is continued as in the corpus, and greedy choices do not depend on the seed.
+	prompt := strings.Join(words.ToString(seq[1000:1020]), " ")
+	continued, _ := words.Generate(GenerateOptions{Prompt: prompt, MaxLength: 10, Seed: 1})
+	if words.Frequency(seq[1000:1020]) != 1 || fmt.Sprint(continued) != fmt.Sprint(seq[1020:1030]) {
+		t.Errorf("Generated %v, expected %v!", words.ToString(continued), words.ToString(seq[1020:1030]))
+	}
+	greedy := GenerateOptions{Prompt: "w16", MaxLength: 10, MaxContext: 1, TopK: 1}
+	first, _ := words.Generate(greedy)
+	greedy.Seed = 99
+	second, _ := words.Generate(greedy)
+	if fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Errorf("Greedy generation depends on the seed: %v and %v!", first, second)
+	}
+	if _, err := words.Generate(GenerateOptions{Prompt: "w16 qwertyuiop", MaxLength: 5}); err == nil {
+		t.Errorf("Expected an OOVError!")
+	}
+}
+
+// Corpora built from a reader, from tokens and from integers should match the corpus built from the same file.
+func TestConstructors(t *testing.T) {
+	data, err := os.ReadFile(strings.Join([]string{path, "/data/test_corpus.txt"}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from_reader, err := NewCorpusFromReader(strings.NewReader(string(data)), ClassicTokenizer{LowerCase: true}, NoSegments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, _ := ReadTokens(strings.NewReader(string(data)), ClassicTokenizer{LowerCase: true})
+	from_tokens := NewCorpusFromTokens(tokens)
+	from_ints, err := NewCorpusFromInts(corpus.Corpus(), corpus.voc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range []*Corpus{from_reader, from_tokens, from_ints} {
+		if !sameArrays(other.seq, corpus.seq) || !sameArrays(other.sfx, corpus.sfx) || other.voc.Size() != corpus.voc.Size() {
+			t.Errorf("Corpus built from alternative input differs: %s", other.Info())
+		}
+	}
+	// Integers outside the vocabulary should be rejected.
+	if _, err := NewCorpusFromInts([]int{0, 1, 2}, testVocabulary("a", "b")); err == nil {
+		t.Errorf("Expected an error for a sequence element outside the vocabulary!")
+	}
+}
+
+// The built-in tokenizers should split lines as documented.
+func TestTokenizers(t *testing.T) {
+	regexp_tokenizer, _ := NewRegexpTokenizer(`[0-9]+`, false)
+	gaps_tokenizer, _ := NewRegexpTokenizer(`,\s*`, true)
+	tests := []struct {
+		tokenizer Tokenizer
+		line      string
+		tokens    []string
+	}{
+		{WhitespaceTokenizer{}, " a  b\tc ", []string{"a", "b", "c"}},
+		{ByteTokenizer{}, "aé", []string{"a", "\xc3", "\xa9"}},
+		{CharTokenizer{}, "ée\u0301", []string{"é", "e", "\u0301"}},
+		{CharTokenizer{Graphemes: true}, "ée\u0301🇬🇧👍🏽", []string{"é", "e\u0301", "🇬🇧", "👍🏽"}},
+		{regexp_tokenizer, "a1b22c333", []string{"1", "22", "333"}},
+		{gaps_tokenizer, "a, b,c,", []string{"a", "b", "c"}},
+		{WordTokenizer{}, "Can't stop, e.g. 3,000.5 times!", []string{"Can't", "stop", ",", "e.g", ".", "3,000.5", "times", "!"}},
+		{WordTokenizer{}, "Größe naïve café", []string{"Größe", "naïve", "café"}},
+		{WordTokenizer{}, "東京タワーへ", []string{"東", "京", "タワー", "へ"}},
+		{WordTokenizer{}, "\xff\xff\xff a", []string{"\xff", "\xff", "\xff", "a"}},
+		{LowerCase(WhitespaceTokenizer{}), "The CAT", []string{"the", "cat"}},
+		{ClassicTokenizer{LowerCase: true, ReturnChars: true}, "Aé", []string{"a", "XXX"}},
+	}
+	for _, test := range tests {
+		tokens := test.tokenizer.Tokenize(test.line)
+		if strings.Join(tokens, "|") != strings.Join(test.tokens, "|") || len(tokens) != len(test.tokens) {
+			t.Errorf("%T tokenized %q as %q, expected %q!", test.tokenizer, test.line, tokens, test.tokens)
+		}
+	}
+}
+
 // Benchmark for making a corpus from a text file.
 func BenchmarkCorpus(b *testing.B) {
 	for i := 0; i < b.N; i++ {
@@ -58,6 +1095,15 @@ func BenchmarkNgrams(b *testing.B) {
 	}
 }
 
+// Benchmark for enumerating ngrams in the corpus with their frequencies up to a specified length.
+func BenchmarkEachNgram(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		corpus.EachNgram(NgramOptions{MinOrder: 1, MaxOrder: MAX_NGRAM_LENGTH - 1}, func(ngram []int, frequency, slo, shi int) bool {
+			return true
+		})
+	}
+}
+
 // Benchmark for generating frequencies of ngrams in the corpus up to a specified length.
 func BenchmarkFreqs(b *testing.B) {
 	for i := 0; i < b.N; i++ {
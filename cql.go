@@ -0,0 +1,391 @@
+package corpustools
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Queries in the Corpus Query Language (CQL) of CQP match sequences of tokens by their attributes, e.g.
+//
+//	[word="run.*" & pos="V.*"] [pos="DT"]? [pos="N.*"]
+//
+// A query is a sequence of token elements, each of which is one of:
+//
+//	[attr="regexp"]  a token whose attribute matches a regular expression (the whole value must match)
+//	[attr!="regexp"] a token whose attribute does not match
+//	"regexp"         a token whose word form matches, i.e. [word="regexp"]
+//	[]               any token
+//
+// Within brackets, tests can be combined with & (and), | (or) and ! (not), and grouped with parentheses. A %c after a
+// regular expression makes it case-insensitive. Each element can be followed by a quantifier: ? (optional), * (any
+// number), + (one or more), {n} or {m,n}.
+//
+// Queries are anchored on the suffix array: the elements which restrict the word form are looked up there, and the rest
+// of the query is matched around the occurrences of the most selective of them. A query which does not restrict any word
+// form is matched at every position of the corpus, which takes time in proportion to the corpus length multiplied by the
+// number of ways its variable-length elements can be matched at a position.
+
+// Returned when a query cannot be parsed.
+type QueryError struct {
+	Query  string
+	Offset int // The byte offset in the query at which the error was found.
+	Reason string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("corpustools: invalid query %q at offset %d: %s", e.Query, e.Offset, e.Reason)
+}
+
+//
+// Search methods.
+//
+
+// Returns the occurrences of a CQL query which do not span a boundary of the given kind, in order of position and then
+// length.
+func (corpus *Corpus) FindQuery(query string, within Boundary) (matches []PatternMatch, err error) {
+	parser := &cqlParser{corpus: corpus, query: query}
+	elems, err := parser.parse()
+	if err != nil {
+		return nil, err
+	}
+	return corpus.findElements(elems, within), nil
+}
+
+// Returns the distinct sequences of word forms which match a CQL query without spanning a boundary of the given kind,
+// with their frequencies, in descending order of frequency.
+func (corpus *Corpus) QueryNgrams(query string, within Boundary) (ngrams []PatternNgram, err error) {
+	matches, err := corpus.FindQuery(query, within)
+	if err != nil {
+		return nil, err
+	}
+	return corpus.matchNgrams(matches), nil
+}
+
+//
+// Token tests.
+//
+
+// Passes tokens whose value of an attribute is one of those allowed.
+type layerTest struct {
+	seq     intArray
+	allowed []bool // Whether each type in the attribute's vocabulary is allowed.
+	word    bool   // Whether the attribute is the word form.
+}
+
+func (test layerTest) matches(cpos int) bool {
+	return test.allowed[test.seq.At(cpos)]
+}
+
+func (test layerTest) wordTypes() (types []int, ok bool) {
+	if !test.word {
+		return nil, false
+	}
+	types = make([]int, 0)
+	for type_int, allowed := range test.allowed {
+		if allowed {
+			types = append(types, type_int)
+		}
+	}
+	return types, true
+}
+
+// Passes tokens which pass both of two tests.
+type andTest struct {
+	a, b tokenTest
+}
+
+func (test andTest) matches(cpos int) bool {
+	return test.a.matches(cpos) && test.b.matches(cpos)
+}
+
+func (test andTest) wordTypes() (types []int, ok bool) {
+	a_types, a_ok := test.a.wordTypes()
+	b_types, b_ok := test.b.wordTypes()
+	switch {
+	case a_ok && b_ok:
+		in_b := make(map[int]bool, len(b_types))
+		for _, type_int := range b_types {
+			in_b[type_int] = true
+		}
+		types = make([]int, 0)
+		for _, type_int := range a_types {
+			if in_b[type_int] {
+				types = append(types, type_int)
+			}
+		}
+		return types, true
+	case a_ok:
+		return a_types, true
+	}
+	return b_types, b_ok
+}
+
+// Passes tokens which pass either of two tests.
+type orTest struct {
+	a, b tokenTest
+}
+
+func (test orTest) matches(cpos int) bool {
+	return test.a.matches(cpos) || test.b.matches(cpos)
+}
+
+func (test orTest) wordTypes() (types []int, ok bool) {
+	a_types, a_ok := test.a.wordTypes()
+	b_types, b_ok := test.b.wordTypes()
+	if !a_ok || !b_ok {
+		return nil, false
+	}
+	seen := make(map[int]bool, len(a_types))
+	types = make([]int, 0, len(a_types)+len(b_types))
+	for _, type_list := range [][]int{a_types, b_types} {
+		for _, type_int := range type_list {
+			if !seen[type_int] {
+				seen[type_int] = true
+				types = append(types, type_int)
+			}
+		}
+	}
+	return types, true
+}
+
+// Passes tokens which fail a test.
+type notTest struct {
+	a tokenTest
+}
+
+func (test notTest) matches(cpos int) bool {
+	return !test.a.matches(cpos)
+}
+
+func (test notTest) wordTypes() ([]int, bool) {
+	return nil, false
+}
+
+//
+// Parsing.
+//
+
+// A recursive descent parser of CQL queries, which compiles each test against the attribute layers of a corpus.
+type cqlParser struct {
+	corpus *Corpus
+	query  string
+	pos    int
+}
+
+// Parses the whole query.
+func (p *cqlParser) parse() (elems []queryElement, err error) {
+	for p.skipSpace(); p.pos < len(p.query); p.skipSpace() {
+		var elem queryElement
+		if elem, err = p.element(); err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	if len(elems) == 0 {
+		return nil, p.error("empty query")
+	}
+	return elems, nil
+}
+
+// Parses a token element and its quantifier.
+func (p *cqlParser) element() (elem queryElement, err error) {
+	elem.min, elem.max = 1, 1
+	switch p.peek() {
+	case '[':
+		p.pos++
+		p.skipSpace()
+		if p.peek() != ']' {
+			if elem.test, err = p.expr(); err != nil {
+				return
+			}
+		}
+		if err = p.expect(']'); err != nil {
+			return
+		}
+	case '"':
+		if elem.test, err = p.comparison(WordAttribute, false); err != nil {
+			return
+		}
+	default:
+		return elem, p.error("expected [ or \"")
+	}
+	// Parse the quantifier.
+	switch p.peek() {
+	case '?':
+		elem.min, elem.max = 0, 1
+		p.pos++
+	case '*':
+		elem.min, elem.max = 0, -1
+		p.pos++
+	case '+':
+		elem.min, elem.max = 1, -1
+		p.pos++
+	case '{':
+		p.pos++
+		end := strings.IndexByte(p.query[p.pos:], '}')
+		if end < 0 {
+			return elem, p.error("unterminated quantifier")
+		}
+		bounds := strings.Split(p.query[p.pos:p.pos+end], ",")
+		min, err1 := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		max, err2 := strconv.Atoi(strings.TrimSpace(bounds[len(bounds)-1]))
+		if len(bounds) > 2 || err1 != nil || err2 != nil || min < 0 || max < min {
+			return elem, p.error("malformed quantifier")
+		}
+		elem.min, elem.max = min, max
+		p.pos += end + 1
+	}
+	return
+}
+
+// Parses a disjunction of conjunctions.
+func (p *cqlParser) expr() (test tokenTest, err error) {
+	if test, err = p.conjunction(); err != nil {
+		return
+	}
+	for p.skipSpace(); p.peek() == '|'; p.skipSpace() {
+		p.pos++
+		var other tokenTest
+		if other, err = p.conjunction(); err != nil {
+			return
+		}
+		test = orTest{test, other}
+	}
+	return
+}
+
+// Parses a conjunction of unary tests.
+func (p *cqlParser) conjunction() (test tokenTest, err error) {
+	if test, err = p.unary(); err != nil {
+		return
+	}
+	for p.skipSpace(); p.peek() == '&'; p.skipSpace() {
+		p.pos++
+		var other tokenTest
+		if other, err = p.unary(); err != nil {
+			return
+		}
+		test = andTest{test, other}
+	}
+	return
+}
+
+// Parses a negation, a parenthesized expression or a comparison of an attribute.
+func (p *cqlParser) unary() (test tokenTest, err error) {
+	p.skipSpace()
+	switch {
+	case p.peek() == '!':
+		p.pos++
+		if test, err = p.unary(); err != nil {
+			return
+		}
+		return notTest{test}, nil
+	case p.peek() == '(':
+		p.pos++
+		if test, err = p.expr(); err != nil {
+			return
+		}
+		return test, p.expect(')')
+	}
+	// Parse the attribute name and operator.
+	start := p.pos
+	for p.pos < len(p.query) && (p.query[p.pos] == '_' || unicode.IsLetter(rune(p.query[p.pos])) || unicode.IsDigit(rune(p.query[p.pos]))) {
+		p.pos++
+	}
+	name := p.query[start:p.pos]
+	if name == "" {
+		return nil, p.error("expected an attribute name")
+	}
+	p.skipSpace()
+	negate := strings.HasPrefix(p.query[p.pos:], "!=")
+	if negate {
+		p.pos += 2
+	} else if err = p.expect('='); err != nil {
+		return
+	}
+	p.skipSpace()
+	return p.comparison(name, negate)
+}
+
+// Parses a quoted regular expression and any flags, returning the test of an attribute against it.
+func (p *cqlParser) comparison(name string, negate bool) (test tokenTest, err error) {
+	start := p.pos
+	voc, seq, found := p.corpus.layer(name)
+	if !found {
+		return nil, p.error(fmt.Sprintf("unknown attribute %s", name))
+	}
+	if err = p.expect('"'); err != nil {
+		return
+	}
+	// Read the expression, in which \" stands for a quote and other escapes are left to the regular expression.
+	var expr strings.Builder
+	for {
+		if p.pos >= len(p.query) {
+			p.pos = start
+			return nil, p.error("unterminated string")
+		}
+		c := p.query[p.pos]
+		p.pos++
+		if c == '"' {
+			break
+		}
+		if c == '\\' && p.pos < len(p.query) && p.query[p.pos] == '"' {
+			c = '"'
+			p.pos++
+		}
+		expr.WriteByte(c)
+	}
+	flags := ""
+	if strings.HasPrefix(p.query[p.pos:], "%c") {
+		flags = "(?i)"
+		p.pos += 2
+	}
+	re, re_err := regexp.Compile(flags + "^(?:" + expr.String() + ")$")
+	if re_err != nil {
+		p.pos = start
+		return nil, p.error(re_err.Error())
+	}
+	// A literal word form can be looked up directly rather than matched against the whole vocabulary.
+	if literal, complete := re.LiteralPrefix(); complete && name == WordAttribute && !negate {
+		types := make([]int, 0, 1)
+		if type_int, found := voc.ID(literal); found {
+			types = append(types, type_int)
+		}
+		return typeTest{seq: seq, types: types}, nil
+	}
+	allowed := make([]bool, voc.Size())
+	for type_int := range allowed {
+		allowed[type_int] = re.MatchString(voc.String(type_int)) != negate
+	}
+	return layerTest{seq: seq, allowed: allowed, word: name == WordAttribute}, nil
+}
+
+func (p *cqlParser) skipSpace() {
+	for p.pos < len(p.query) && unicode.IsSpace(rune(p.query[p.pos])) {
+		p.pos++
+	}
+}
+
+// Returns the next byte of the query, or 0 at the end.
+func (p *cqlParser) peek() byte {
+	if p.pos >= len(p.query) {
+		return 0
+	}
+	return p.query[p.pos]
+}
+
+func (p *cqlParser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return p.error(fmt.Sprintf("expected %c", c))
+	}
+	p.pos++
+	return nil
+}
+
+func (p *cqlParser) error(reason string) error {
+	return &QueryError{Query: p.query, Offset: p.pos, Reason: reason}
+}
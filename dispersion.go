@@ -0,0 +1,110 @@
+package corpustools
+
+import (
+	"math"
+	"sort"
+)
+
+// Dispersion measures how evenly the occurrences of a sequence are spread over the parts of a corpus, which are either
+// its documents or a number of equal-sized parts. A sequence with a high frequency may be common only because a few
+// documents use it heavily; dispersion distinguishes such sequences from those used throughout the corpus.
+
+// Measures of the dispersion of a sequence over the parts of a corpus. Parts containing no tokens are ignored.
+type Dispersion struct {
+	Frequency int     // The number of occurrences counted, each in the part in which it starts.
+	Parts     int     // The number of parts.
+	Range     int     // The number of parts in which the sequence occurs.
+	JuillandD float64 // Juilland's D, from 0 (all occurrences in one part) to 1 (spread evenly), or NaN if there are fewer than two parts.
+	GriesDP   float64 // Gries' deviation of proportions, from 0 (spread in proportion to part sizes) to nearly 1 (all occurrences in one small part).
+	DPNorm    float64 // Gries' DP normalized to lie in [0, 1] (Lijffijt and Gries, 2012).
+}
+
+// Returns the number of documents in which a sequence occurs without spanning a document boundary.
+func (corpus *Corpus) DocumentFrequency(seq []int) (count int) {
+	seen := make(map[int]bool)
+	for _, cpos := range corpus.FindWithin(seq, DocumentBoundary) {
+		doc, _ := corpus.Locate(cpos)
+		if !seen[doc] {
+			seen[doc] = true
+			count++
+		}
+	}
+	return
+}
+
+// Returns the dispersion of a sequence over the documents of the corpus. Occurrences spanning two documents are not
+// counted.
+func (corpus *Corpus) DocumentDispersion(seq []int) Dispersion {
+	starts := intsOf(corpus.docs)
+	return corpus.dispersion(corpus.FindWithin(seq, DocumentBoundary), starts)
+}
+
+// Returns the dispersion of a sequence over n parts of the corpus of (as nearly as possible) equal numbers of tokens.
+// Each occurrence belongs to the part in which it starts, including those which span two parts.
+func (corpus *Corpus) PartDispersion(seq []int, n int) Dispersion {
+	if n < 1 {
+		n = 1
+	}
+	starts := make([]int, n)
+	for i := range starts {
+		starts[i] = i * corpus.seq.Len() / n
+	}
+	return corpus.dispersion(corpus.Find(seq), starts)
+}
+
+// Returns the dispersion of the occurrences at the given corpus positions over the parts starting at the given positions.
+func (corpus *Corpus) dispersion(indices []int, starts []int) (d Dispersion) {
+	d.Frequency = len(indices)
+	// Count the occurrences in each part.
+	counts := make([]int, len(starts))
+	for _, cpos := range indices {
+		counts[sort.Search(len(starts), func(i int) bool { return starts[i] > cpos })-1]++
+	}
+	// Find the size of each non-empty part, and its share of the occurrences.
+	sizes, freqs := make([]float64, 0, len(starts)), make([]float64, 0, len(starts))
+	for i, start := range starts {
+		end := corpus.seq.Len()
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if end > start {
+			sizes = append(sizes, float64(end-start))
+			freqs = append(freqs, float64(counts[i]))
+			if counts[i] > 0 {
+				d.Range++
+			}
+		}
+	}
+	d.Parts = len(sizes)
+	if d.Frequency == 0 || d.Parts == 0 {
+		d.JuillandD, d.GriesDP, d.DPNorm = math.NaN(), math.NaN(), math.NaN()
+		return
+	}
+	// Juilland's D is based on the coefficient of variation of the relative frequencies in each part.
+	d.JuillandD = math.NaN()
+	if d.Parts > 1 {
+		mean, sd := 0.0, 0.0
+		for i := range sizes {
+			mean += freqs[i] / sizes[i]
+		}
+		mean /= float64(d.Parts)
+		for i := range sizes {
+			sd += math.Pow(freqs[i]/sizes[i]-mean, 2)
+		}
+		sd = math.Sqrt(sd / float64(d.Parts))
+		d.JuillandD = 1 - sd/mean/math.Sqrt(float64(d.Parts-1))
+	}
+	// Gries' DP compares the share of the occurrences in each part with the share of the corpus.
+	total, min_share := float64(corpus.seq.Len()), 1.0
+	for i := range sizes {
+		share := sizes[i] / total
+		d.GriesDP += math.Abs(freqs[i]/float64(d.Frequency) - share)
+		min_share = math.Min(min_share, share)
+	}
+	d.GriesDP /= 2
+	d.DPNorm = d.GriesDP
+	if min_share < 1 {
+		d.DPNorm = d.GriesDP / (1 - min_share)
+	}
+	return
+}
@@ -0,0 +1,292 @@
+package corpustools
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Each document in a corpus may carry metadata: a set of key/value fields such as its filename, author or genre. A corpus
+// built from files records the "filename" field of each document, and further fields can be read from a sidecar file.
+// Metadata is saved with the corpus index, and can be used to restrict queries to a subcorpus of documents.
+
+//
+// Document metadata.
+//
+
+// Returns a copy of the metadata fields of a document (empty if it has none).
+func (corpus *Corpus) Metadata(doc int) (fields map[string]string) {
+	fields = make(map[string]string)
+	if corpus.meta != nil {
+		for key, value := range corpus.meta[doc] {
+			fields[key] = value
+		}
+	}
+	return
+}
+
+// Returns the name of the file a document was read from, or "" if it was not read from a file.
+func (corpus *Corpus) DocumentName(doc int) string {
+	if corpus.meta == nil {
+		return ""
+	}
+	return corpus.meta[doc]["filename"]
+}
+
+// Sets a metadata field of a document.
+func (corpus *Corpus) SetMetadata(doc int, key, value string) {
+	if corpus.meta == nil {
+		corpus.meta = make([]map[string]string, corpus.docs.Len())
+	}
+	if corpus.meta[doc] == nil {
+		corpus.meta[doc] = make(map[string]string)
+	}
+	corpus.meta[doc][key] = value
+}
+
+// Reads metadata fields for the documents of a corpus from a sidecar file, which is either JSON (if its name ends in
+// .json, before any .gz or .bz2) or CSV. A JSON sidecar holds either an object mapping filenames to objects of fields,
+// or an array of objects with a "filename" field. A CSV sidecar has a header row naming its columns, one of which must
+// be "filename". Entries are matched to documents by their full filename, or else by its base name; entries matching no
+// document are ignored.
+func (corpus *Corpus) ReadMetadata(filename string) error {
+	fh, err := openInput(filename)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	var entries []map[string]string
+	if strings.EqualFold(filepath.Ext(strings.TrimSuffix(strings.TrimSuffix(filename, ".gz"), ".bz2")), ".json") {
+		entries, err = readJSONMetadata(fh)
+	} else {
+		entries, err = readCSVMetadata(fh)
+	}
+	if err != nil {
+		return fmt.Errorf("corpustools: reading metadata from %s: %w", filename, err)
+	}
+	// Index the documents by their full and base names.
+	by_name := make(map[string][]int)
+	for doc := 0; doc < corpus.NumDocuments(); doc++ {
+		if name := corpus.DocumentName(doc); name != "" {
+			by_name[name] = append(by_name[name], doc)
+			if base := filepath.Base(name); base != name {
+				by_name[base] = append(by_name[base], doc)
+			}
+		}
+	}
+	for _, entry := range entries {
+		docs, found := by_name[entry["filename"]]
+		if !found {
+			docs = by_name[filepath.Base(entry["filename"])]
+		}
+		for _, doc := range docs {
+			for key, value := range entry {
+				if key != "filename" {
+					corpus.SetMetadata(doc, key, value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the entries of a JSON metadata file, with values other than strings given as JSON text.
+func readJSONMetadata(r io.Reader) (entries []map[string]string, err error) {
+	var raw interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err = decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	// Converts a JSON object to an entry.
+	entry := func(obj interface{}) (map[string]string, error) {
+		fields, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object of fields")
+		}
+		entry := make(map[string]string, len(fields))
+		for key, value := range fields {
+			if str, ok := value.(string); ok {
+				entry[key] = str
+			} else {
+				text, _ := json.Marshal(value)
+				entry[key] = string(text)
+			}
+		}
+		return entry, nil
+	}
+	switch raw := raw.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(raw))
+		for name := range raw {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			e, err := entry(raw[name])
+			if err != nil {
+				return nil, err
+			}
+			e["filename"] = name
+			entries = append(entries, e)
+		}
+	case []interface{}:
+		for _, obj := range raw {
+			e, err := entry(obj)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := e["filename"]; !ok {
+				return nil, fmt.Errorf("entry without a filename field")
+			}
+			entries = append(entries, e)
+		}
+	default:
+		return nil, fmt.Errorf("expected an object or an array")
+	}
+	return
+}
+
+// Returns the entries of a CSV metadata file.
+func readCSVMetadata(r io.Reader) (entries []map[string]string, err error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+	header := records[0]
+	has_filename := false
+	for _, key := range header {
+		has_filename = has_filename || key == "filename"
+	}
+	if !has_filename {
+		return nil, fmt.Errorf("no filename column")
+	}
+	for _, record := range records[1:] {
+		entry := make(map[string]string, len(header))
+		for i, key := range header {
+			entry[key] = record[i]
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+//
+// Locations of matches.
+//
+
+// A position in the corpus, given as a document and an offset within it.
+type Location struct {
+	Document int
+	Offset   int
+}
+
+// Returns the locations at which a given sequence occurs, in corpus order.
+func (corpus *Corpus) FindLocations(seq []int) (locations []Location) {
+	indices := corpus.Find(seq)
+	sort.Ints(indices)
+	locations = make([]Location, len(indices))
+	for i, cpos := range indices {
+		locations[i].Document, locations[i].Offset = corpus.Locate(cpos)
+	}
+	return
+}
+
+//
+// Subcorpora.
+//
+
+// A subset of the documents of a corpus, which can be searched using the suffix array of the whole corpus.
+type Subcorpus struct {
+	corpus *Corpus
+	docs   []int  // The documents in the subcorpus, in order.
+	member []bool // Whether each document of the corpus is in the subcorpus.
+	length int    // The number of tokens in the subcorpus.
+}
+
+// Returns the subcorpus of documents whose metadata satisfy a predicate.
+func (corpus *Corpus) Subcorpus(keep func(fields map[string]string) bool) *Subcorpus {
+	sub := &Subcorpus{corpus: corpus, docs: make([]int, 0), member: make([]bool, corpus.NumDocuments())}
+	for doc := range sub.member {
+		if keep(corpus.Metadata(doc)) {
+			start, end := corpus.DocumentRange(doc)
+			sub.docs = append(sub.docs, doc)
+			sub.member[doc] = true
+			sub.length += end - start
+		}
+	}
+	return sub
+}
+
+// Returns the subcorpus of documents whose metadata field key has the given value.
+func (corpus *Corpus) SubcorpusWhere(key, value string) *Subcorpus {
+	return corpus.Subcorpus(func(fields map[string]string) bool {
+		return fields[key] == value
+	})
+}
+
+// Returns the documents in the subcorpus, in order.
+func (sub *Subcorpus) Documents() []int {
+	return append([]int(nil), sub.docs...)
+}
+
+// Returns the number of tokens in the subcorpus.
+func (sub *Subcorpus) Len() int {
+	return sub.length
+}
+
+// Returns whether a corpus position lies in the subcorpus.
+func (sub *Subcorpus) Contains(cpos int) bool {
+	doc, _ := sub.corpus.Locate(cpos)
+	return sub.member[doc]
+}
+
+// Returns the corpus indices where a given sequence occurs within a single document of the subcorpus.
+func (sub *Subcorpus) Find(seq []int) (indices []int) {
+	indices = make([]int, 0)
+	for _, cpos := range sub.corpus.FindWithin(seq, DocumentBoundary) {
+		if sub.Contains(cpos) {
+			indices = append(indices, cpos)
+		}
+	}
+	return
+}
+
+// Returns the locations at which a given sequence occurs in the subcorpus, in corpus order.
+func (sub *Subcorpus) FindLocations(seq []int) (locations []Location) {
+	indices := sub.Find(seq)
+	sort.Ints(indices)
+	locations = make([]Location, len(indices))
+	for i, cpos := range indices {
+		locations[i].Document, locations[i].Offset = sub.corpus.Locate(cpos)
+	}
+	return
+}
+
+// Returns the number of times a sequence occurs in the subcorpus.
+func (sub *Subcorpus) Frequency(seq []int) int {
+	return len(sub.Find(seq))
+}
+
+// Returns the probability of a sequence in the subcorpus, dividing its frequency by the number of positions at which a
+// sequence of its length could start without crossing the end of a document.
+func (sub *Subcorpus) Probability(seq []int) float64 {
+	positions := 0
+	for _, doc := range sub.docs {
+		start, end := sub.corpus.DocumentRange(doc)
+		if n := end - start - (len(seq) - 1); n > 0 {
+			positions += n
+		}
+	}
+	if positions == 0 {
+		return 0.0
+	}
+	return float64(sub.Frequency(seq)) / float64(positions)
+}
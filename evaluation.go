@@ -0,0 +1,147 @@
+package corpustools
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// A language model is evaluated on held-out text by mapping the text's tokens into the ID space of the model's
+// vocabulary and scoring each of its sentences. Tokens which are not in the vocabulary are handled according to an
+// UnknownPolicy, and the cross-entropy and perplexity are computed over the tokens which are scored.
+
+// How tokens which are not in the vocabulary of a language model are scored.
+type UnknownPolicy int
+
+const (
+	SkipUnknown UnknownPolicy = iota // Unknown tokens are not scored, and the tokens after them are scored without context.
+	MapUnknown                       // Unknown tokens are scored as UnknownToken, which must be in the vocabulary.
+)
+
+// The token standing for unknown tokens under the MapUnknown policy. It must occur in the training corpus, e.g. by
+// replacing rare tokens with it.
+const UnknownToken = "<unk>"
+
+// Implemented by language models which know how the text they were trained on was tokenized.
+type tokenizerModel interface {
+	Tokenizer() Tokenizer
+}
+
+// Options for Evaluate.
+type EvaluationOptions struct {
+	Tokenizer    Tokenizer     // Splits lines into tokens; if nil, the model's Tokenizer if it has one, as models of a corpus do.
+	Segmentation Segmentation  // Divides the text into sentences, each of which is scored without the context of the others.
+	Unknown      UnknownPolicy // How unknown tokens are scored.
+}
+
+// The score of a sentence of held-out text.
+type SentenceScore struct {
+	Tokens  int     // The number of tokens in the sentence.
+	OOVs    int     // The number of tokens which are not in the vocabulary.
+	LogProb float64 // The base 2 logarithm of the probability of the scored tokens.
+}
+
+// The evaluation of a language model on held-out text.
+type Evaluation struct {
+	Tokens       int     // The number of tokens in the text.
+	OOVs         int     // The number of tokens which are not in the vocabulary.
+	Scored       int     // The number of tokens scored, which excludes OOVs under the SkipUnknown policy.
+	LogProb      float64 // The base 2 logarithm of the probability of the scored tokens.
+	CrossEntropy float64 // The mean negated log probability of the scored tokens, in bits.
+	Perplexity   float64 // 2 to the power of the cross-entropy.
+	OOVRate      float64 // The proportion of tokens which are not in the vocabulary.
+	Sentences    []SentenceScore
+}
+
+// Returns the evaluation of a language model on the text read from r, which may be compressed, and is tokenized and
+// divided into sentences as specified by the options.
+func Evaluate(lm LanguageModel, r io.Reader, options EvaluationOptions) (eval *Evaluation, err error) {
+	if r, err = decompress(r, "<reader>"); err != nil {
+		return nil, err
+	}
+	return evaluate(lm, r, "<reader>", options)
+}
+
+// Returns the evaluation of a language model on the text of a file, which may be compressed (see compress.go).
+func EvaluateFile(lm LanguageModel, filename string, options EvaluationOptions) (eval *Evaluation, err error) {
+	fh, err := openInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return evaluate(lm, fh, filename, options)
+}
+
+func evaluate(lm LanguageModel, r io.Reader, name string, options EvaluationOptions) (eval *Evaluation, err error) {
+	voc := lm.Vocabulary()
+	unknown := -1
+	if options.Unknown == MapUnknown {
+		var found bool
+		if unknown, found = voc.ID(UnknownToken); !found {
+			return nil, fmt.Errorf("corpustools: the vocabulary has no %s token", UnknownToken)
+		}
+	}
+	tokenizer := options.Tokenizer
+	if model, ok := lm.(tokenizerModel); ok && tokenizer == nil {
+		tokenizer = model.Tokenizer()
+	}
+	if tokenizer == nil {
+		return nil, fmt.Errorf("corpustools: no tokenizer was given, and the model has none")
+	}
+	eval = &Evaluation{Sentences: make([]SentenceScore, 0)}
+	// Score the sentence read so far, in parts separated by skipped unknown tokens.
+	sentence, part := SentenceScore{}, make([]int, 0)
+	end_part := func() {
+		sentence.LogProb += lm.Score(part)
+		part = part[:0]
+	}
+	end_sentence := func() {
+		end_part()
+		if sentence.Tokens > 0 {
+			eval.Sentences = append(eval.Sentences, sentence)
+			eval.Tokens += sentence.Tokens
+			eval.OOVs += sentence.OOVs
+			eval.LogProb += sentence.LogProb
+		}
+		sentence = SentenceScore{}
+	}
+	err = eachLine(r, name, 0, func(line string) error {
+		if options.Segmentation == LineSegments || (options.Segmentation == ParagraphSegments && strings.TrimSpace(line) == "") {
+			end_sentence()
+		}
+		for _, token := range tokenizer.Tokenize(line) {
+			if token == "" {
+				continue
+			}
+			sentence.Tokens++
+			type_int, found := voc.ID(token)
+			if !found {
+				sentence.OOVs++
+				if options.Unknown == SkipUnknown {
+					end_part()
+					continue
+				}
+				type_int = unknown
+			}
+			part = append(part, type_int)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	end_sentence()
+	eval.Scored = eval.Tokens
+	if options.Unknown == SkipUnknown {
+		eval.Scored -= eval.OOVs
+	}
+	if eval.Scored > 0 {
+		eval.CrossEntropy = -eval.LogProb / float64(eval.Scored)
+		eval.Perplexity = math.Exp2(eval.CrossEntropy)
+	}
+	if eval.Tokens > 0 {
+		eval.OOVRate = float64(eval.OOVs) / float64(eval.Tokens)
+	}
+	return
+}
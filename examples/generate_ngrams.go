@@ -19,11 +19,11 @@ func main() {
 		fmt.Printf("%d %dgrams found in %v.\n", len(ngrams), n, t2.Sub(t1))
 	}
 
-	// Report the frequencies of ngrams.
-	for n := 1; n <= 10; n++ {
-		ngrams := corpus.Ngrams(n)
-		for j, ngram := range ngrams {
-			fmt.Printf("%dgram %d = %v (%v) has frequency of %d.\n", n, j, corpus.ToString(ngram), ngram, corpus.Frequency(ngram))
-		}
-	}
+	// Report the frequencies of ngrams, enumerating them without holding them all in memory.
+	j := 0
+	corpus.EachNgram(corpustools.NgramOptions{MinOrder: 1, MaxOrder: 10}, func(ngram []int, frequency, slo, shi int) bool {
+		fmt.Printf("%dgram %d = %v (%v) has frequency of %d.\n", len(ngram), j, corpus.ToString(ngram), ngram, frequency)
+		j++
+		return true
+	})
 }
\ No newline at end of file
@@ -14,13 +14,10 @@ func main() {
 	// Get the list of comparison terms.
 	t1 := time.Now()
 	seqs := make([][]int, 0)
-	for order := 1; order <= 3; order++ {
-		for _, ngram := range corpus.Ngrams(order) {
-			if corpus.Frequency(ngram) >= 20 {
-				seqs = append(seqs, ngram)
-			}
-		}
-	}
+	corpus.EachNgram(corpustools.NgramOptions{MinOrder: 1, MaxOrder: 3, MinFrequency: 20}, func(ngram []int, frequency, slo, shi int) bool {
+		seqs = append(seqs, append([]int(nil), ngram...))
+		return true
+	})
 	t2 := time.Now()
 	fmt.Printf("%d sequences in nearest neighbor set (took %v).\n", len(seqs), t2.Sub(t1))
 
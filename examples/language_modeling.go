@@ -29,4 +29,12 @@ func main() {
 		_, L_mn := corpustools.SummarizeProbabilities(probs)
 		fmt.Printf("The mean cross-entropy of the corpus with itself using length %d predictors is %.2f bits.\n", predictor_length, L_mn)
 	}
-}
\ No newline at end of file
+
+	// Smoothing gives ngrams which do not occur in the training data a nonzero probability.
+	lm, err := corpustools.NewKneserNey(corpus, 3)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("The mean cross-entropy of the corpus with itself using a Kneser-Ney trigram model is %.2f bits.\n", -lm.Score(corpus_sequence)/float64(len(corpus_sequence)))
+}
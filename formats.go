@@ -0,0 +1,216 @@
+package corpustools
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Readers for annotated input, in which each token is given on its own line together with its annotations, which become
+// attribute layers of the corpus (see attributes.go). Three formats are supported:
+//
+//	vertical: the word form and then the value of each attribute, separated by tabs. Structural tags on lines of their
+//	          own mark documents (<doc key="value" ...>, whose fields become the document's metadata) and segments
+//	          (<s>); other tags are skipped. Blank lines also separate segments.
+//	CoNLL-U:  the ten columns of the Universal Dependencies format, whose columns after the word form become the
+//	          attributes lemma, upos, xpos, feats, head, deprel, deps and misc. Sentences are segments, and a
+//	          "# newdoc" comment starts a document. Multiword token and empty node lines are skipped.
+//	TSV:      a header row naming the columns, then the word form and the value of each attribute, separated by tabs.
+//	          The first column holds the word form, whatever its name. Blank lines separate segments.
+
+// Returned when a line of annotated input is malformed.
+type FormatError struct {
+	Filename string
+	Line     int
+	Reason   string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("corpustools: %s:%d: %s", e.Filename, e.Line, e.Reason)
+}
+
+// The attributes of a corpus read from CoNLL-U, in the order of their columns.
+var CoNLLUAttributes = []string{"lemma", "upos", "xpos", "feats", "head", "deprel", "deps", "misc"}
+
+//
+// Constructors.
+//
+
+// Creates and returns a corpus from a vertical file with a column for each of the named attributes.
+func NewCorpusFromVertical(filename string, attributes []string) (corpus *Corpus, err error) {
+	return newCorpusFromAnnotatedFile(filename, func(builder *corpusBuilder, r io.Reader) error {
+		return builder.readVertical(r, filename, attributes)
+	})
+}
+
+// Creates and returns a corpus from vertical text read from r, which may be compressed.
+func NewCorpusFromVerticalReader(r io.Reader, attributes []string) (corpus *Corpus, err error) {
+	return newCorpusFromAnnotated(r, "<reader>", func(builder *corpusBuilder, r io.Reader) error {
+		return builder.readVertical(r, "<reader>", attributes)
+	})
+}
+
+// Creates and returns a corpus from a CoNLL-U file.
+func NewCorpusFromCoNLLU(filename string) (corpus *Corpus, err error) {
+	return newCorpusFromAnnotatedFile(filename, func(builder *corpusBuilder, r io.Reader) error {
+		return builder.readCoNLLU(r, filename)
+	})
+}
+
+// Creates and returns a corpus from CoNLL-U text read from r, which may be compressed.
+func NewCorpusFromCoNLLUReader(r io.Reader) (corpus *Corpus, err error) {
+	return newCorpusFromAnnotated(r, "<reader>", func(builder *corpusBuilder, r io.Reader) error {
+		return builder.readCoNLLU(r, "<reader>")
+	})
+}
+
+// Creates and returns a corpus from a TSV file with a header row.
+func NewCorpusFromTSV(filename string) (corpus *Corpus, err error) {
+	return newCorpusFromAnnotatedFile(filename, func(builder *corpusBuilder, r io.Reader) error {
+		return builder.readTSV(r, filename)
+	})
+}
+
+// Creates and returns a corpus from TSV text with a header row read from r, which may be compressed.
+func NewCorpusFromTSVReader(r io.Reader) (corpus *Corpus, err error) {
+	return newCorpusFromAnnotated(r, "<reader>", func(builder *corpusBuilder, r io.Reader) error {
+		return builder.readTSV(r, "<reader>")
+	})
+}
+
+func newCorpusFromAnnotatedFile(filename string, read func(builder *corpusBuilder, r io.Reader) error) (corpus *Corpus, err error) {
+	fh, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return newCorpusFromAnnotated(fh, filename, read)
+}
+
+// Reads an annotated corpus from r, decompressing it if necessary.
+func newCorpusFromAnnotated(r io.Reader, name string, read func(builder *corpusBuilder, r io.Reader) error) (corpus *Corpus, err error) {
+	if r, err = decompress(r, name); err != nil {
+		return nil, err
+	}
+	builder := newCorpusBuilder()
+	if err = read(builder, r); err != nil {
+		return nil, err
+	}
+	if len(builder.docs) == 0 {
+		builder.startDocument(nil)
+	}
+	return builder.corpus(), nil
+}
+
+//
+// Readers.
+//
+
+// Matches the fields of a structural tag, e.g. id="a1".
+var tagFields = regexp.MustCompile(`([\w.:-]+)\s*=\s*"([^"]*)"`)
+
+// Reads the tokens of a vertical file into the corpus being built.
+func (builder *corpusBuilder) readVertical(r io.Reader, name string, attributes []string) error {
+	builder.setAttributes(attributes)
+	return eachNumberedLine(r, name, func(line string, line_num int) error {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "<doc>" || strings.HasPrefix(trimmed, "<doc "):
+			fields := make(map[string]string)
+			for _, field := range tagFields.FindAllStringSubmatch(trimmed, -1) {
+				fields[field[1]] = field[2]
+			}
+			builder.startDocument(fields)
+		case trimmed == "<s>" || strings.HasPrefix(trimmed, "<s ") || trimmed == "</s>" || trimmed == "":
+			builder.startSegment()
+		case strings.HasPrefix(trimmed, "<") && strings.HasSuffix(trimmed, ">"):
+		default:
+			return builder.addColumns(strings.Split(line, "\t"), name, line_num)
+		}
+		return nil
+	})
+}
+
+// Reads the tokens of a CoNLL-U file into the corpus being built.
+func (builder *corpusBuilder) readCoNLLU(r io.Reader, name string) error {
+	builder.setAttributes(CoNLLUAttributes)
+	return eachNumberedLine(r, name, func(line string, line_num int) error {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			comment := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			if comment == "newdoc" || strings.HasPrefix(comment, "newdoc ") {
+				fields := make(map[string]string)
+				if key, value, found := strings.Cut(comment, "="); found {
+					fields[strings.TrimSpace(strings.TrimPrefix(key, "newdoc"))] = strings.TrimSpace(value)
+				}
+				builder.startDocument(fields)
+			}
+			return nil
+		case strings.TrimSpace(line) == "":
+			builder.startSegment()
+			return nil
+		}
+		columns := strings.Split(line, "\t")
+		if len(columns) != 10 {
+			return &FormatError{Filename: name, Line: line_num, Reason: fmt.Sprintf("expected 10 columns, found %d", len(columns))}
+		}
+		// Skip multiword tokens (e.g. 1-2) and empty nodes (e.g. 1.1), whose words are given by other lines.
+		id := columns[0]
+		if strings.ContainsAny(id, "-.") {
+			return nil
+		}
+		if _, err := strconv.Atoi(id); err != nil {
+			return &FormatError{Filename: name, Line: line_num, Reason: fmt.Sprintf("malformed token ID %q", id)}
+		}
+		return builder.addColumns(columns[1:], name, line_num)
+	})
+}
+
+// Reads the tokens of a TSV file with a header row into the corpus being built.
+func (builder *corpusBuilder) readTSV(r io.Reader, name string) error {
+	header := true
+	return eachNumberedLine(r, name, func(line string, line_num int) error {
+		switch {
+		case header:
+			columns := strings.Split(line, "\t")
+			for i, column := range columns {
+				if column == "" || (i > 0 && column == WordAttribute) {
+					return &FormatError{Filename: name, Line: line_num, Reason: fmt.Sprintf("invalid column name %q", column)}
+				}
+			}
+			builder.setAttributes(columns[1:])
+			header = false
+		case strings.TrimSpace(line) == "":
+			builder.startSegment()
+		default:
+			return builder.addColumns(strings.Split(line, "\t"), name, line_num)
+		}
+		return nil
+	})
+}
+
+// Adds a token given as its word form and the value of each attribute, checking the number of columns.
+func (builder *corpusBuilder) addColumns(columns []string, name string, line_num int) error {
+	if len(columns) != len(builder.attrs)+1 {
+		return &FormatError{Filename: name, Line: line_num, Reason: fmt.Sprintf("expected %d columns, found %d", len(builder.attrs)+1, len(columns))}
+	}
+	if columns[0] == "" {
+		return &FormatError{Filename: name, Line: line_num, Reason: "empty word form"}
+	}
+	if len(builder.docs) == 0 {
+		builder.startDocument(nil)
+	}
+	builder.addAnnotated(columns[0], columns[1:])
+	return nil
+}
+
+// Calls fn with each line read from r and its line number, stopping at the first error.
+func eachNumberedLine(r io.Reader, name string, fn func(line string, line_num int) error) error {
+	line_num := 0
+	return eachLine(r, name, 0, func(line string) error {
+		line_num++
+		return fn(line, line_num)
+	})
+}
@@ -0,0 +1,122 @@
+package corpustools
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Text is generated from a variable-order Markov model of the corpus: each token is sampled from the tokens which follow
+// the longest suffix of the text so far (up to a maximum length) which occurs in the corpus, in proportion to their
+// frequencies, as in the ∞-gram model (see infini_gram.go). The distribution can be sharpened or flattened with a
+// temperature and truncated to its most probable tokens. A long maximum context tends to reproduce passages of the corpus
+// verbatim, while a short one gives more novel text.
+
+// Options for Generate.
+type GenerateOptions struct {
+	Prompt      string  // Text to continue, split into tokens with the corpus's tokenizer.
+	MaxLength   int     // The number of tokens to generate.
+	MaxContext  int     // The longest context used to predict each token, or 0 for no limit.
+	Temperature float64 // Each probability is raised to the power 1/Temperature and renormalized; 1 if zero.
+	TopK        int     // If positive, only the TopK most probable tokens are sampled.
+	TopP        float64 // If in (0, 1), only the most probable tokens whose total probability reaches TopP are sampled.
+	Seed        int64   // The seed of the random choice of tokens.
+}
+
+// Returns a sequence of tokens generated to follow the prompt (which is not included). An OOVError is returned if the
+// prompt contains tokens which are not in the vocabulary, and an error if the corpus has no tokenizer to split it.
+func (corpus *Corpus) Generate(options GenerateOptions) (seq []int, err error) {
+	if corpus.seq.Len() == 0 {
+		return nil, fmt.Errorf("corpustools: cannot generate text from an empty corpus")
+	}
+	if options.Temperature < 0.0 {
+		return nil, fmt.Errorf("corpustools: temperature %g is negative", options.Temperature)
+	}
+	text := make([]int, 0)
+	if options.Prompt != "" {
+		tokenizer, err := corpus.queryTokenizer()
+		if err != nil {
+			return nil, err
+		}
+		if tokens := tokenizer.Tokenize(options.Prompt); strings.Join(tokens, "") != "" {
+			if text, err = corpus.Lookup(tokens); err != nil {
+				return nil, err
+			}
+		}
+	}
+	prompt_length := len(text)
+	rng := rand.New(rand.NewSource(options.Seed))
+	for i := 0; i < options.MaxLength; i++ {
+		context := text
+		if options.MaxContext > 0 {
+			context = lastTokens(text, options.MaxContext)
+		}
+		suffix := context[len(context)-corpus.longestFollowedSuffix(context, len(context)):]
+		text = append(text, corpus.sampleNext(suffix, options, rng))
+	}
+	return text[prompt_length:], nil
+}
+
+// Returns a token sampled from those following a sequence, which must occur followed by a token.
+func (corpus *Corpus) sampleNext(seq []int, options GenerateOptions, rng *rand.Rand) int {
+	truncated := options.TopK > 0 || (options.TopP > 0.0 && options.TopP < 1.0)
+	if !truncated && (options.Temperature == 0.0 || options.Temperature == 1.0) {
+		// Sampling an occurrence of the sequence chooses each following token in proportion to its frequency.
+		slo, shi := 0, corpus.sfx.Len()-1
+		if len(seq) > 0 {
+			slo, shi = corpus.SuffixSearch(seq)
+		}
+		for {
+			if cpos := corpus.sfx.At(slo + rng.Intn(shi-slo+1)); cpos+len(seq) < corpus.seq.Len() {
+				return corpus.seq.At(cpos + len(seq))
+			}
+		}
+	}
+	// Order the following tokens by descending frequency, breaking ties by type so that the choice is reproducible.
+	counts := corpus.followingCounts(seq, true)
+	words := make([]int, 0, len(counts))
+	for word := range counts {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	temperature := options.Temperature
+	if temperature == 0.0 {
+		temperature = 1.0
+	}
+	// Scale the frequencies by the greatest, so that low temperatures do not overflow.
+	weights := make([]float64, len(words))
+	for i, word := range words {
+		weights[i] = math.Pow(float64(counts[word])/float64(counts[words[0]]), 1.0/temperature)
+	}
+	if options.TopK > 0 && options.TopK < len(weights) {
+		weights = weights[:options.TopK]
+	}
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	if options.TopP > 0.0 && options.TopP < 1.0 {
+		cumulative := 0.0
+		for i, weight := range weights {
+			if cumulative += weight; cumulative >= options.TopP*total {
+				weights, total = weights[:i+1], cumulative
+				break
+			}
+		}
+	}
+	// Choose a token in proportion to its weight.
+	target := rng.Float64() * total
+	for i, weight := range weights {
+		if target -= weight; target < 0.0 {
+			return words[i]
+		}
+	}
+	return words[len(weights)-1]
+}
@@ -0,0 +1,575 @@
+package corpustools
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// A saved corpus index is laid out as follows, with all integers little-endian:
+//
+//	header:     magic "CTIX", uint32 format version
+//	vocabulary: uint64 number of types, then each type's string in integer order as a uvarint length and its bytes
+//	metadata:   uint64 number of documents with metadata (0 if there is none), then for each document a uvarint number
+//	            of fields and each field's key and value in key order, as strings are stored in the vocabulary
+//	attributes: uint64 number of attribute layers other than the word forms, then for each its name and vocabulary
+//	seq:        array of corpus tokens
+//	sfx:        array of suffix pointers
+//	optional:   any further arrays (the LCP array, document and segment boundaries, and the sequences of the attribute
+//	            layers in order), identified by their kind
+//
+// An array is a uint32 element width in bits, a uint32 kind, a uint64 element count and a uint64 count of the 64-bit
+// words which follow and hold the elements. Elements of width 64 or 32 are stored one after another (with the last word
+// padded if need be); elements of any other width are bit-packed as in packedArray. Every section after the header is
+// followed by the CRC-32C checksum of its contents as a uint32, and then zero padding to a multiple of 8 bytes from the
+// start of the index so that array words are aligned.
+
+const (
+	indexMagic   = "CTIX"
+	indexVersion = 1
+)
+
+// Kinds of array in an index.
+const (
+	arraySeq = iota + 1
+	arraySfx
+	arrayLCP
+	arrayDocuments
+	arraySegments
+	arrayAttribute
+)
+
+var indexTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Returned when a corpus index cannot be loaded because it is malformed, corrupted or of an unsupported version.
+type IndexError struct {
+	Reason string
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("corpustools: invalid corpus index: %s", e.Reason)
+}
+
+//
+// Saving.
+//
+
+// Writes the corpus, including its suffix array, to w in the binary index format.
+func (corpus *Corpus) Save(w io.Writer) error {
+	iw := &indexWriter{w: bufio.NewWriter(w), crc: crc32.New(indexTable)}
+	// Write the header.
+	iw.write([]byte(indexMagic))
+	iw.uint32(indexVersion)
+	// Write the vocabulary in integer order.
+	iw.startSection()
+	iw.vocabulary(corpus.voc)
+	iw.endSection()
+	// Write the metadata of each document.
+	iw.startSection()
+	iw.uint64(uint64(len(corpus.meta)))
+	for _, fields := range corpus.meta {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		iw.uvarint(uint64(len(keys)))
+		for _, key := range keys {
+			iw.string(key)
+			iw.string(fields[key])
+		}
+	}
+	iw.endSection()
+	// Write the names and vocabularies of the attribute layers.
+	iw.startSection()
+	iw.uint64(uint64(len(corpus.attrs)))
+	for _, attr := range corpus.attrs {
+		iw.string(attr.name)
+		iw.vocabulary(attr.voc)
+	}
+	iw.endSection()
+	// Write the corpus and suffix arrays.
+	iw.intArray(arraySeq, corpus.seq)
+	iw.intArray(arraySfx, corpus.sfx)
+	if corpus.lcp != nil {
+		iw.intArray(arrayLCP, corpus.lcp)
+	}
+	iw.intArray(arrayDocuments, corpus.docs)
+	iw.intArray(arraySegments, corpus.segs)
+	for _, attr := range corpus.attrs {
+		iw.intArray(arrayAttribute, attr.seq)
+	}
+	if iw.err != nil {
+		return iw.err
+	}
+	return iw.w.Flush()
+}
+
+// Writes the corpus index to a file.
+func (corpus *Corpus) SaveFile(filename string) error {
+	fh, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = corpus.Save(fh); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// Writes the sections of an index, keeping track of the offset and checksum. The first error is retained and later
+// writes are skipped.
+type indexWriter struct {
+	w   *bufio.Writer
+	off int64
+	crc hash.Hash32
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (iw *indexWriter) write(p []byte) {
+	if iw.err != nil {
+		return
+	}
+	_, iw.err = iw.w.Write(p)
+	iw.crc.Write(p)
+	iw.off += int64(len(p))
+}
+
+func (iw *indexWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(iw.buf[:], v)
+	iw.write(iw.buf[:4])
+}
+
+func (iw *indexWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(iw.buf[:], v)
+	iw.write(iw.buf[:8])
+}
+
+func (iw *indexWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(iw.buf[:], v)
+	iw.write(iw.buf[:n])
+}
+
+func (iw *indexWriter) string(str string) {
+	iw.uvarint(uint64(len(str)))
+	iw.write([]byte(str))
+}
+
+// Writes the number of types in a vocabulary and then each type's string in integer order.
+func (iw *indexWriter) vocabulary(voc *Vocabulary) {
+	iw.uint64(uint64(len(voc.strs)))
+	for _, type_str := range voc.strs {
+		iw.string(type_str)
+	}
+}
+
+func (iw *indexWriter) startSection() {
+	iw.crc.Reset()
+}
+
+// Writes the checksum of the section and pads to the next 8 byte boundary.
+func (iw *indexWriter) endSection() {
+	iw.uint32(iw.crc.Sum32())
+	for iw.off%8 != 0 {
+		iw.write([]byte{0})
+	}
+}
+
+func (iw *indexWriter) intArray(kind uint32, arr intArray) {
+	iw.startSection()
+	switch arr := arr.(type) {
+	case narrowArray:
+		iw.arrayHeader(32, kind, arr.Len())
+		for _, v := range arr {
+			iw.uint32(v)
+		}
+		if len(arr)%2 == 1 {
+			iw.uint32(0)
+		}
+	case *packedArray:
+		iw.arrayHeader(arr.width, kind, arr.Len())
+		for _, w := range arr.words {
+			iw.uint64(w)
+		}
+	default:
+		iw.arrayHeader(64, kind, arr.Len())
+		for i := 0; i < arr.Len(); i++ {
+			iw.uint64(uint64(arr.At(i)))
+		}
+	}
+	iw.endSection()
+}
+
+func (iw *indexWriter) arrayHeader(width uint, kind uint32, n int) {
+	iw.uint32(uint32(width))
+	iw.uint32(kind)
+	iw.uint64(uint64(n))
+	iw.uint64(uint64(arrayWords(width, n)))
+}
+
+// Returns the number of 64-bit words used to store an array of n elements of the given width.
+func arrayWords(width uint, n int) int {
+	switch width {
+	case 64:
+		return n
+	case 32:
+		return (n + 1) / 2
+	}
+	return packedWords(n, width)
+}
+
+//
+// Loading.
+//
+
+// Reads a corpus, including its suffix array, from an index written by Save.
+func LoadCorpus(r io.Reader) (corpus *Corpus, err error) {
+	stream := bufio.NewReader(r)
+	ir := &indexReader{r: stream, stream: stream, crc: crc32.New(indexTable)}
+	corpus, err = ir.header()
+	if err != nil {
+		return nil, err
+	}
+	// Read the corpus and suffix arrays.
+	_, corpus.seq = ir.intArray()
+	_, corpus.sfx = ir.intArray()
+	for ir.more() {
+		corpus.setIndexArray(ir.intArray())
+	}
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	corpus.setDefaultBoundaries()
+	if err = corpus.checkIndex(); err != nil {
+		return nil, err
+	}
+	return corpus, nil
+}
+
+// Reads a corpus index from a file.
+func LoadCorpusFile(filename string) (corpus *Corpus, err error) {
+	fh, err := openInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return LoadCorpus(fh)
+}
+
+// Sets an optional array read from an index. Arrays of unknown kinds are ignored.
+func (corpus *Corpus) setIndexArray(kind uint32, arr intArray) {
+	switch kind {
+	case arrayLCP:
+		corpus.lcp = arr
+	case arrayDocuments:
+		corpus.docs = arr
+	case arraySegments:
+		corpus.segs = arr
+	case arrayAttribute:
+		for i := range corpus.attrs {
+			if corpus.attrs[i].seq == nil {
+				corpus.attrs[i].seq = arr
+				break
+			}
+		}
+	}
+}
+
+// Treats a corpus read from an index without boundaries as a single document.
+func (corpus *Corpus) setDefaultBoundaries() {
+	if corpus.docs == nil || corpus.segs == nil {
+		corpus.docs, corpus.segs = singleUnit(corpus.seq.Len())
+	}
+}
+
+// Checks that a loaded corpus is consistent, so that searches over it cannot go out of bounds.
+func (corpus *Corpus) checkIndex() error {
+	if corpus.sfx.Len() != corpus.seq.Len() {
+		return &IndexError{Reason: "suffix array and corpus lengths differ"}
+	}
+	for cpos := 0; cpos < corpus.seq.Len(); cpos++ {
+		if type_int := corpus.seq.At(cpos); type_int < 0 || type_int >= corpus.voc.Size() {
+			return &IndexError{Reason: "corpus contains a type outside the vocabulary"}
+		}
+	}
+	for spos := 0; spos < corpus.sfx.Len(); spos++ {
+		if cpos := corpus.sfx.At(spos); cpos < 0 || cpos >= corpus.seq.Len() {
+			return &IndexError{Reason: "suffix array points outside the corpus"}
+		}
+	}
+	if corpus.lcp != nil && corpus.lcp.Len() != corpus.seq.Len() {
+		return &IndexError{Reason: "LCP array and corpus lengths differ"}
+	}
+	// A common prefix cannot run past the end of the corpus from either of its suffixes.
+	for spos := 0; corpus.lcp != nil && spos < corpus.lcp.Len(); spos++ {
+		limit := 0
+		if spos > 0 {
+			limit = corpus.seq.Len() - corpus.sfx.At(spos-1)
+			if rest := corpus.seq.Len() - corpus.sfx.At(spos); rest < limit {
+				limit = rest
+			}
+		}
+		if length := corpus.lcp.At(spos); length < 0 || length > limit {
+			return &IndexError{Reason: "LCP array contains a prefix longer than its suffixes"}
+		}
+	}
+	if corpus.meta != nil && len(corpus.meta) != corpus.docs.Len() {
+		return &IndexError{Reason: "metadata and document counts differ"}
+	}
+	if err := corpus.checkAttributes(); err != nil {
+		return err
+	}
+	for _, attr := range corpus.attrs {
+		for cpos := 0; cpos < attr.seq.Len(); cpos++ {
+			if type_int := attr.seq.At(cpos); type_int < 0 || type_int >= attr.voc.Size() {
+				return &IndexError{Reason: "attribute contains a value outside its vocabulary"}
+			}
+		}
+	}
+	for _, starts := range []intArray{corpus.docs, corpus.segs} {
+		for i := 0; i < starts.Len(); i++ {
+			if (i == 0 && starts.At(i) != 0) || (i > 0 && starts.At(i) < starts.At(i-1)) || starts.At(i) > corpus.seq.Len() {
+				return &IndexError{Reason: "malformed document or segment boundaries"}
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that every attribute layer was read and is as long as the corpus.
+func (corpus *Corpus) checkAttributes() error {
+	for _, attr := range corpus.attrs {
+		if attr.seq == nil || attr.seq.Len() != corpus.seq.Len() {
+			return &IndexError{Reason: fmt.Sprintf("attribute %s is missing or of the wrong length", attr.name)}
+		}
+	}
+	return nil
+}
+
+// Reads the sections of an index, keeping track of the offset and checksum. The first error is retained and later
+// reads return zero values.
+type indexReader struct {
+	r      io.Reader
+	stream *bufio.Reader // The buffered stream the index is read from, if its size is not known.
+	size   int64         // The size of the index, if it is not read from a stream.
+	off    int64
+	crc    hash.Hash32
+	err    error
+	buf    [8]byte
+}
+
+// Reads the header and vocabulary of an index, returning a corpus without its arrays.
+func (ir *indexReader) header() (corpus *Corpus, err error) {
+	// Check the header.
+	magic := make([]byte, len(indexMagic))
+	ir.read(magic)
+	version := ir.uint32()
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	if string(magic) != indexMagic {
+		return nil, &IndexError{Reason: "not a corpus index"}
+	}
+	if version != indexVersion {
+		return nil, &IndexError{Reason: fmt.Sprintf("unsupported format version %d", version)}
+	}
+	// Read the vocabulary.
+	corpus = &Corpus{}
+	ir.startSection()
+	corpus.voc = ir.vocabulary()
+	ir.endSection()
+	// Read the metadata of each document.
+	if ir.err == nil {
+		ir.startSection()
+		num_docs := ir.uint64()
+		for doc := uint64(0); doc < num_docs && ir.err == nil; doc++ {
+			fields := make(map[string]string)
+			num_fields := ir.uvarint()
+			for i := uint64(0); i < num_fields && ir.err == nil; i++ {
+				key := ir.string()
+				fields[key] = ir.string()
+			}
+			corpus.meta = append(corpus.meta, fields)
+		}
+		ir.endSection()
+	}
+	// Read the names and vocabularies of the attribute layers, whose sequences follow as optional arrays.
+	if ir.err == nil {
+		ir.startSection()
+		num_attrs := ir.uint64()
+		for i := uint64(0); i < num_attrs && ir.err == nil; i++ {
+			name := ir.string()
+			corpus.attrs = append(corpus.attrs, attribute{name: name, voc: ir.vocabulary()})
+		}
+		ir.endSection()
+	}
+	if ir.err != nil {
+		return nil, ir.err
+	}
+	return corpus, nil
+}
+
+func (ir *indexReader) read(p []byte) {
+	if ir.err != nil {
+		return
+	}
+	n, err := io.ReadFull(ir.r, p)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = &IndexError{Reason: "unexpected end of index"}
+	}
+	ir.err = err
+	ir.crc.Write(p[:n])
+	ir.off += int64(n)
+}
+
+// Returns whether there is any more of the index to read.
+func (ir *indexReader) more() bool {
+	if ir.err != nil {
+		return false
+	}
+	if ir.stream != nil {
+		_, err := ir.stream.Peek(1)
+		return err == nil
+	}
+	return ir.off < ir.size
+}
+
+func (ir *indexReader) uint32() uint32 {
+	ir.read(ir.buf[:4])
+	if ir.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(ir.buf[:4])
+}
+
+func (ir *indexReader) uint64() uint64 {
+	ir.read(ir.buf[:8])
+	if ir.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(ir.buf[:8])
+}
+
+func (ir *indexReader) uvarint() uint64 {
+	if ir.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(byteReader{ir})
+	if err != nil {
+		ir.err = &IndexError{Reason: "malformed integer"}
+	}
+	return v
+}
+
+// Reads a string stored as a uvarint length and its bytes.
+func (ir *indexReader) string() string {
+	length := ir.uvarint()
+	if length > 1<<30 && ir.err == nil {
+		ir.err = &IndexError{Reason: "malformed string"}
+	}
+	if ir.err != nil {
+		return ""
+	}
+	str := make([]byte, length)
+	ir.read(str)
+	return string(str)
+}
+
+// Reads a vocabulary written by indexWriter.vocabulary. A vocabulary with a duplicate type is an error.
+func (ir *indexReader) vocabulary() (voc *Vocabulary) {
+	voc = NewVocabulary()
+	num_types := ir.uint64()
+	for type_int := 0; uint64(type_int) < num_types && ir.err == nil; type_int++ {
+		if voc.add(ir.string()) != type_int && ir.err == nil {
+			ir.err = &IndexError{Reason: "duplicate type in vocabulary"}
+		}
+	}
+	return
+}
+
+func (ir *indexReader) startSection() {
+	ir.crc.Reset()
+}
+
+// Checks the checksum of the section and skips the padding to the next 8 byte boundary.
+func (ir *indexReader) endSection() {
+	sum := ir.crc.Sum32()
+	if ir.uint32() != sum && ir.err == nil {
+		ir.err = &IndexError{Reason: "checksum mismatch"}
+	}
+	for ir.off%8 != 0 && ir.err == nil {
+		ir.read(ir.buf[:1])
+	}
+}
+
+// Reads the header of an array section, returning the element width, kind and count.
+func (ir *indexReader) arrayHeader() (width uint, kind uint32, n uint64) {
+	ir.startSection()
+	width = uint(ir.uint32())
+	kind = ir.uint32()
+	n = ir.uint64()
+	nwords := ir.uint64()
+	if ir.err != nil {
+		return
+	}
+	if width == 0 || width > 64 || n > math.MaxInt/64 {
+		ir.err = &IndexError{Reason: fmt.Sprintf("unsupported array of %d elements of width %d", n, width)}
+	} else if nwords != uint64(arrayWords(width, int(n))) {
+		ir.err = &IndexError{Reason: "array length mismatch"}
+	}
+	return
+}
+
+func (ir *indexReader) intArray() (kind uint32, arr intArray) {
+	width, kind, n := ir.arrayHeader()
+	if ir.err != nil {
+		return kind, nil
+	}
+	// Grow the array as words are read, so that a corrupt length cannot force a huge allocation.
+	words := make([]uint64, 0, 1<<12)
+	for i := 0; i < arrayWords(width, int(n)) && ir.err == nil; i++ {
+		words = append(words, ir.uint64())
+	}
+	ir.endSection()
+	if ir.err != nil {
+		return kind, nil
+	}
+	return kind, arrayFromWords(words, width, int(n))
+}
+
+// Returns an array of n elements of the given width stored in words.
+func arrayFromWords(words []uint64, width uint, n int) intArray {
+	switch width {
+	case 64:
+		arr := make(wideArray, n)
+		for i := range arr {
+			arr[i] = int(words[i])
+		}
+		return arr
+	case 32:
+		arr := make(narrowArray, n)
+		for i := range arr {
+			arr[i] = uint32(words[i/2] >> (32 * uint(i%2)))
+		}
+		return arr
+	}
+	return &packedArray{words: words, width: width, n: n, mask: (1 << width) - 1}
+}
+
+// Adapts an indexReader to io.ByteReader.
+type byteReader struct {
+	ir *indexReader
+}
+
+func (bc byteReader) ReadByte() (byte, error) {
+	bc.ir.read(bc.ir.buf[:1])
+	return bc.ir.buf[0], bc.ir.err
+}
@@ -0,0 +1,130 @@
+package corpustools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// A saved corpus should load back identically, and corruption should be detected.
+func TestSaveLoad(t *testing.T) {
+	var buf bytes.Buffer
+	if err := corpus.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	loaded, err := LoadCorpus(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameArrays(loaded.seq, corpus.seq) || !sameArrays(loaded.sfx, corpus.sfx) {
+		t.Errorf("Loaded corpus differs from saved corpus: %s", loaded.Info())
+	}
+	corpus.voc.Each(func(type_int int, type_str string) bool {
+		if loaded_int, _ := loaded.voc.ID(type_str); loaded_int != type_int {
+			t.Errorf("Loaded vocabulary maps %q to %d rather than %d!", type_str, loaded_int, type_int)
+		}
+		return true
+	})
+	// The LCP array should be saved and loaded if it has been computed.
+	enhanced, _ := NewCorpusFromInts(corpus.Corpus(), corpus.voc)
+	enhanced.SetLCPArray()
+	var enhanced_buf bytes.Buffer
+	enhanced.Save(&enhanced_buf)
+	if loaded, err := LoadCorpus(&enhanced_buf); err != nil || !sameArrays(loaded.lcp, enhanced.lcp) {
+		t.Errorf("LCP array was not saved and loaded (%v)!", err)
+	}
+	// Document and segment boundaries should be saved and loaded.
+	segmented, _ := NewCorpusFromReader(strings.NewReader("a b\nc\n\nd e f\n"), WhitespaceTokenizer{}, LineSegments)
+	var segmented_buf bytes.Buffer
+	segmented.Save(&segmented_buf)
+	if loaded, err := LoadCorpus(&segmented_buf); err != nil || !sameArrays(loaded.segs, segmented.segs) || !sameArrays(loaded.docs, segmented.docs) {
+		t.Errorf("Boundaries were not saved and loaded (%v)!", err)
+	}
+	// Document metadata should be saved and loaded.
+	segmented.SetMetadata(0, "genre", "news")
+	segmented_buf.Reset()
+	segmented.Save(&segmented_buf)
+	if loaded, err := LoadCorpus(&segmented_buf); err != nil || loaded.Metadata(0)["genre"] != "news" {
+		t.Errorf("Metadata was not saved and loaded (%v)!", err)
+	}
+	// Text queries on a loaded corpus should fail until its tokenizer is set.
+	if _, err := loaded.FrequencyOf("w67"); err == nil {
+		t.Errorf("Text query on a loaded corpus without a tokenizer succeeded!")
+	}
+	loaded.SetTokenizer(WhitespaceTokenizer{})
+	if frequency, err := loaded.FrequencyOf("w67"); err != nil || frequency != corpus.Frequency([]int{corpus.voc.ids["w67"]}) {
+		t.Errorf("Text query on a loaded corpus gave %d (%v)!", frequency, err)
+	}
+	// Flipping a bit in the suffix array should fail the checksum.
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-16] ^= 1
+	if _, err := LoadCorpus(bytes.NewReader(corrupt)); err == nil {
+		t.Errorf("Corrupted index loaded without error!")
+	}
+	// A truncated index should fail to load.
+	if _, err := LoadCorpus(bytes.NewReader(data[:len(data)/2])); err == nil {
+		t.Errorf("Truncated index loaded without error!")
+	}
+	// An index of another version should be rejected.
+	other := append([]byte(nil), data...)
+	other[4] = indexVersion + 1
+	if _, err := LoadCorpus(bytes.NewReader(other)); err == nil || err.Error() != (&IndexError{Reason: "unsupported format version 2"}).Error() {
+		t.Errorf("Index of version 2 was not rejected (%v)!", err)
+	}
+}
+
+// A memory-mapped index should behave like the corpus it was saved from, whatever its storage layout.
+func TestMapCorpus(t *testing.T) {
+	packed, _ := NewCorpusFromInts(corpus.Corpus(), corpus.voc)
+	packed.PackTokens()
+	packed.SetLCPArray()
+	for _, saved := range []*Corpus{corpus, packed} {
+		filename := t.TempDir() + "/test.idx"
+		if err := saved.SaveFile(filename); err != nil {
+			t.Fatal(err)
+		}
+		for _, verify := range []bool{false, true} {
+			mapped, err := MapCorpus(filename, verify)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameArrays(mapped.seq, corpus.seq) || !sameArrays(mapped.sfx, corpus.sfx) || mapped.HasLCPArray() != saved.HasLCPArray() {
+				t.Errorf("Mapped corpus differs from saved corpus: %s", mapped.Info())
+			}
+			for _, unigram := range corpus.Ngrams(1) {
+				if mapped.Frequency(unigram) != corpus.Frequency(unigram) {
+					t.Errorf("Mapped corpus gives frequency %d for %v rather than %d!", mapped.Frequency(unigram), unigram, corpus.Frequency(unigram))
+				}
+			}
+			if err := mapped.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	// A suffix array pointing outside the corpus should be rejected even without verifying checksums.
+	broken, _ := NewCorpusFromInts([]int{0, 1, 0}, corpus.voc)
+	broken.sfx = wideArray{0, 1, 7}
+	filename := t.TempDir() + "/broken.idx"
+	if err := broken.SaveFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MapCorpus(filename, false); err == nil {
+		t.Errorf("Mapped an index whose suffix array points outside the corpus!")
+	}
+	// So should an LCP array whose prefixes run past the end of the corpus.
+	broken, _ = NewCorpusFromInts([]int{0, 1, 0, 1, 0}, corpus.voc)
+	broken.SetLCPArray()
+	broken.lcp = wideArray{0, 5, 2, 0, 1}
+	if err := broken.SaveFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	for _, verify := range []bool{false, true} {
+		if _, err := MapCorpus(filename, verify); err == nil {
+			t.Errorf("Mapped an index whose LCP array runs past the corpus!")
+		}
+	}
+	if _, err := LoadCorpusFile(filename); err == nil {
+		t.Errorf("Loaded an index whose LCP array runs past the corpus!")
+	}
+}
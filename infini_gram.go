@@ -0,0 +1,101 @@
+package corpustools
+
+import (
+	"math"
+	"sort"
+)
+
+// An ∞-gram model (Liu et al., 2024) has no fixed order: each token is predicted from the longest suffix of its context
+// which occurs in the corpus followed by a token, using the maximum likelihood estimate of the tokens which follow that
+// suffix. Because the suffix array counts ngrams of any length, this costs only a few binary searches per token. The
+// length of the suffix used shows how much of the context the corpus reproduces, which makes the model useful for
+// studying memorization. The model is sparse: a token which never follows the longest suffix has probability zero, so
+// it is usually interpolated with a smoothed model when scoring text.
+
+// An ∞-gram language model of a corpus.
+type InfiniGram struct {
+	corpus *Corpus
+}
+
+// Creates and returns an ∞-gram language model of a corpus.
+func NewInfiniGram(corpus *Corpus) *InfiniGram {
+	return &InfiniGram{corpus: corpus}
+}
+
+func (lm *InfiniGram) Vocabulary() *Vocabulary {
+	return lm.corpus.Vocabulary()
+}
+
+// Returns the tokenizer of the model's corpus, which Evaluate uses by default.
+func (lm *InfiniGram) Tokenizer() Tokenizer {
+	return lm.corpus.Tokenizer()
+}
+
+// Returns the base 2 logarithm of the probability of a word following the longest suffix of a context which occurs in
+// the corpus, which is -Inf if the word never follows it.
+func (lm *InfiniGram) LogProb(context []int, word int) float64 {
+	if word < 0 || word >= lm.corpus.voc.Size() {
+		return math.Inf(-1)
+	}
+	suffix := context[len(context)-lm.ContextLength(context):]
+	return math.Log2(float64(lm.corpus.Frequency(SeqJoin(suffix, []int{word}))) / float64(lm.corpus.followedFrequency(suffix)))
+}
+
+func (lm *InfiniGram) Score(seq []int) (score float64) {
+	for i, length := range lm.ContextLengths(seq) {
+		suffix := seq[i-length : i]
+		score += math.Log2(float64(lm.corpus.Frequency(seq[i-length:i+1])) / float64(lm.corpus.followedFrequency(suffix)))
+	}
+	return
+}
+
+// Returns the distribution of the tokens following the longest suffix of a context which occurs in the corpus, and the
+// length of that suffix (the effective context length).
+func (lm *InfiniGram) Distribution(context []int) (probs map[int]float64, length int) {
+	length = lm.ContextLength(context)
+	counts := lm.corpus.followingCounts(context[len(context)-length:], true)
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	probs = make(map[int]float64, len(counts))
+	for word, count := range counts {
+		probs[word] = float64(count) / float64(total)
+	}
+	return
+}
+
+// Returns the length of the longest suffix of a context which occurs in the corpus followed by a token.
+func (lm *InfiniGram) ContextLength(context []int) int {
+	return lm.corpus.longestFollowedSuffix(context, len(context))
+}
+
+// Returns the effective context length used to predict each token of a sequence from the tokens before it.
+func (lm *InfiniGram) ContextLengths(seq []int) (lengths []int) {
+	lengths = make([]int, len(seq))
+	for i := 1; i < len(seq); i++ {
+		// A suffix which occurs before a token extends the suffix used for that token by at most one token.
+		lengths[i] = lm.corpus.longestFollowedSuffix(seq[:i], lengths[i-1]+1)
+	}
+	return
+}
+
+// Returns the length, up to a limit, of the longest suffix of a context which occurs in the corpus followed by a token.
+// A suffix with a following token contains shorter suffixes which also have one, so the length is found by binary search.
+func (corpus *Corpus) longestFollowedSuffix(context []int, limit int) int {
+	if limit > len(context) {
+		limit = len(context)
+	}
+	return sort.Search(limit, func(length int) bool {
+		return corpus.followedFrequency(context[len(context)-length-1:]) == 0
+	})
+}
+
+// Returns the number of occurrences of a sequence which are followed by a token, i.e. which do not end the corpus.
+func (corpus *Corpus) followedFrequency(seq []int) int {
+	frequency := corpus.Frequency(seq)
+	if frequency > 0 && len(seq) > 0 && len(seq) <= corpus.seq.Len() && corpus.cmpSuffix(corpus.seq.Len()-len(seq), seq) == 0 {
+		frequency--
+	}
+	return frequency
+}
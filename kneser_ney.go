@@ -0,0 +1,237 @@
+package corpustools
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// Interpolated modified Kneser-Ney smoothing (Chen and Goodman, 1998). The probability of a word following a context
+// interpolates a discounted estimate from the counts of the context with the estimate from its context shortened by one
+// token, down to the uniform distribution over the vocabulary. Ngrams of the model's order are counted by their
+// frequency, and shorter ngrams by their continuation count, the number of distinct tokens which precede them. Counts of
+// one, two, and three or more are discounted separately, by amounts estimated for each order from its counts of counts.
+// All counts are found from the suffix array when they are needed, and the statistics of each context are cached. The
+// cache holds at most maxKNContexts contexts, and is emptied when it is full.
+
+// A language model of a corpus smoothed with interpolated modified Kneser-Ney.
+type KneserNey struct {
+	corpus    *Corpus
+	order     int
+	discounts [][3]float64 // The discounts of counts of one, two, and three or more for ngrams of each order.
+	mutex     sync.Mutex
+	contexts  map[string]*knContext // The cached statistics of contexts, keyed by seqKey.
+}
+
+// The maximum number of contexts whose statistics a KneserNey model caches.
+const maxKNContexts = 1 << 16
+
+// The statistics of the words following a context.
+type knContext struct {
+	counts map[int]int // The count of each word following the context.
+	total  int         // The sum of the counts.
+	gamma  float64     // The weight of the distribution of the shortened context.
+}
+
+// Creates and returns a Kneser-Ney language model of ngrams of up to a given order in a corpus.
+func NewKneserNey(corpus *Corpus, order int) (lm *KneserNey, err error) {
+	if err = checkOrder(corpus, order); err != nil {
+		return nil, err
+	}
+	lm = &KneserNey{corpus: corpus, order: order, discounts: make([][3]float64, order+1), contexts: make(map[string]*knContext)}
+	for n := 1; n <= order; n++ {
+		lm.discounts[n] = corpus.knDiscounts(n, n == order)
+	}
+	return lm, nil
+}
+
+// Returns the order of the model.
+func (lm *KneserNey) Order() int {
+	return lm.order
+}
+
+// Returns the discounts of counts of one, two, and three or more for ngrams of a given order.
+func (lm *KneserNey) Discounts(order int) [3]float64 {
+	return lm.discounts[order]
+}
+
+func (lm *KneserNey) Vocabulary() *Vocabulary {
+	return lm.corpus.Vocabulary()
+}
+
+// Returns the tokenizer of the model's corpus, which Evaluate uses by default.
+func (lm *KneserNey) Tokenizer() Tokenizer {
+	return lm.corpus.Tokenizer()
+}
+
+func (lm *KneserNey) LogProb(context []int, word int) float64 {
+	if word < 0 || word >= lm.corpus.voc.Size() {
+		return math.Inf(-1)
+	}
+	return math.Log2(lm.Prob(context, word))
+}
+
+func (lm *KneserNey) Score(seq []int) float64 {
+	return scoreSequence(lm, seq)
+}
+
+// Returns the probability of a word following a context, of which only the last order-1 tokens are used.
+func (lm *KneserNey) Prob(context []int, word int) float64 {
+	context = lastTokens(context, lm.order-1)
+	p := 1.0 / float64(lm.corpus.voc.Size())
+	for k := 0; k <= len(context); k++ {
+		ctx := lm.context(context[len(context)-k:])
+		if ctx.total == 0 {
+			// A context which is never followed by a word cannot be extended into one which is.
+			break
+		}
+		count := ctx.counts[word]
+		p = math.Max(float64(count)-lm.discount(k+1, count), 0.0)/float64(ctx.total) + ctx.gamma*p
+	}
+	return p
+}
+
+// Returns the discount of a count of an ngram of a given order.
+func (lm *KneserNey) discount(order, count int) float64 {
+	switch {
+	case count == 0:
+		return 0.0
+	case count < 3:
+		return lm.discounts[order][count-1]
+	}
+	return lm.discounts[order][2]
+}
+
+// Returns the statistics of a context, computing them if they are not cached.
+func (lm *KneserNey) context(seq []int) *knContext {
+	key := seqKey(seq)
+	lm.mutex.Lock()
+	ctx, found := lm.contexts[key]
+	lm.mutex.Unlock()
+	if found {
+		return ctx
+	}
+	ctx = &knContext{counts: lm.corpus.followingCounts(seq, len(seq) == lm.order-1)}
+	var n [4]int // n[c] is the number of words with a count of c, or of three or more for c = 3.
+	for _, count := range ctx.counts {
+		ctx.total += count
+		if count > 3 {
+			count = 3
+		}
+		n[count]++
+	}
+	if ctx.total > 0 {
+		d := lm.discounts[len(seq)+1]
+		ctx.gamma = (d[0]*float64(n[1]) + d[1]*float64(n[2]) + d[2]*float64(n[3])) / float64(ctx.total)
+	}
+	lm.mutex.Lock()
+	if len(lm.contexts) >= maxKNContexts {
+		lm.contexts = make(map[string]*knContext)
+	}
+	lm.contexts[key] = ctx
+	lm.mutex.Unlock()
+	return ctx
+}
+
+// Returns a map key for a sequence of integers, which is cheaper to compute than formatting the sequence.
+func seqKey(seq []int) string {
+	buf := make([]byte, 0, len(seq)*2)
+	for _, i := range seq {
+		buf = binary.AppendUvarint(buf, uint64(i))
+	}
+	return string(buf)
+}
+
+//
+// Counting from the suffix array.
+//
+
+// Returns the count of each word following a context: its frequency if raw is true, and otherwise its continuation
+// count.
+func (corpus *Corpus) followingCounts(context []int, raw bool) (counts map[int]int) {
+	counts = make(map[int]int)
+	slo, shi := 0, corpus.sfx.Len()-1
+	if len(context) > 0 {
+		if slo, shi = corpus.SuffixSearch(context); slo == -1 {
+			return
+		}
+	}
+	// The suffixes in the range of the context are ordered by the word which follows it, so the occurrences of each word
+	// are adjacent.
+	prev_word, lefts := -1, make(map[int]bool)
+	for spos := slo; spos <= shi; spos++ {
+		cpos := corpus.sfx.At(spos)
+		if cpos+len(context) >= corpus.seq.Len() {
+			continue
+		}
+		word := corpus.seq.At(cpos + len(context))
+		if raw {
+			counts[word]++
+			continue
+		}
+		if word != prev_word {
+			prev_word, lefts = word, make(map[int]bool)
+		}
+		if left := corpus.leftOf(cpos); !lefts[left] {
+			lefts[left] = true
+			counts[word]++
+		}
+	}
+	return
+}
+
+// Returns the token preceding a corpus position, or -1 at the start of the corpus.
+func (corpus *Corpus) leftOf(cpos int) int {
+	if cpos == 0 {
+		return -1
+	}
+	return corpus.seq.At(cpos - 1)
+}
+
+// Returns the number of distinct tokens preceding the occurrences in a range of the suffix array, counting no further
+// than limit.
+func (corpus *Corpus) continuationCount(slo, shi, limit int) int {
+	lefts := make([]int, 0, limit)
+	for spos := slo; spos <= shi && len(lefts) < limit; spos++ {
+		left := corpus.leftOf(corpus.sfx.At(spos))
+		seen := false
+		for _, other := range lefts {
+			seen = seen || other == left
+		}
+		if !seen {
+			lefts = append(lefts, left)
+		}
+	}
+	return len(lefts)
+}
+
+// Returns the modified Kneser-Ney discounts of counts of one, two, and three or more for ngrams of a given order,
+// estimated from the numbers of ngrams with counts of one to four. Counts are frequencies if raw is true, and otherwise
+// continuation counts. A discount which cannot be estimated (as in a small corpus) falls back to a single absolute
+// discount, and each is limited to its count, so that every word keeps a nonzero probability.
+func (corpus *Corpus) knDiscounts(order int, raw bool) (discounts [3]float64) {
+	var n [5]float64 // n[c] is the number of ngrams with a count of c.
+	corpus.EachNgram(NgramOptions{MinOrder: order}, func(ngram []int, frequency, slo, shi int) bool {
+		count := frequency
+		if !raw {
+			count = corpus.continuationCount(slo, shi, 5)
+		}
+		if count <= 4 {
+			n[count]++
+		}
+		return true
+	})
+	y := n[1] / (n[1] + 2.0*n[2])
+	if math.IsNaN(y) || y <= 0.0 {
+		y = 0.5
+	}
+	for i := range discounts {
+		c := float64(i + 1)
+		d := c - (c+1.0)*y*n[i+2]/n[i+1]
+		if math.IsNaN(d) || math.IsInf(d, 0) || d <= 0.0 {
+			d = y
+		}
+		discounts[i] = math.Min(d, c)
+	}
+	return
+}
@@ -0,0 +1,196 @@
+package corpustools
+
+import (
+	"fmt"
+	"math"
+)
+
+// Language models assign probabilities to the token following a context, using the statistics of a training corpus.
+// Probabilities are given as base 2 logarithms, so that the negated mean log probability of a text is its cross-entropy
+// in bits per token (see SummarizeProbabilities and Evaluate). The ngram models use the last order-1 tokens of a context,
+// and the ∞-gram model (see infini_gram.go) as many as occur in the corpus. Unlike the maximum likelihood model, which is
+// ProbabilityTransition, the smoothed models (add-k, and Kneser-Ney in kneser_ney.go) give every token of the vocabulary
+// a nonzero probability in every context.
+
+// A model of the probability of each token of a vocabulary given the tokens preceding it.
+type LanguageModel interface {
+	// Returns the vocabulary of the tokens the model predicts.
+	Vocabulary() *Vocabulary
+	// Returns the base 2 logarithm of the probability of a word following a context, which may be empty.
+	LogProb(context []int, word int) float64
+	// Returns the base 2 logarithm of the probability of a sequence, each token being predicted from those before it.
+	Score(seq []int) float64
+}
+
+// Returns the base 2 logarithm of the probability of a sequence under a language model.
+func scoreSequence(lm LanguageModel, seq []int) (score float64) {
+	for i := range seq {
+		score += lm.LogProb(seq[:i], seq[i])
+	}
+	return
+}
+
+// Returns an error if a language model of a given order cannot be estimated from a corpus.
+func checkOrder(corpus *Corpus, order int) error {
+	if order < 1 {
+		return fmt.Errorf("corpustools: language model order %d is less than 1", order)
+	}
+	if corpus.seq.Len() == 0 {
+		return fmt.Errorf("corpustools: cannot estimate a language model from an empty corpus")
+	}
+	return nil
+}
+
+// Returns the last n tokens of a context, or the whole context if it is shorter.
+func lastTokens(context []int, n int) []int {
+	if len(context) > n {
+		return context[len(context)-n:]
+	}
+	return context
+}
+
+//
+// Maximum likelihood.
+//
+
+// An unsmoothed ngram language model, whose probabilities are the relative frequencies of the ngrams of a corpus, as given
+// by ProbabilityTransition. Unseen ngrams, and words following unseen contexts, have probability zero.
+type MLE struct {
+	corpus *Corpus
+	order  int
+}
+
+// Creates and returns a maximum likelihood language model of ngrams of up to a given order in a corpus.
+func NewMLE(corpus *Corpus, order int) (lm *MLE, err error) {
+	if err = checkOrder(corpus, order); err != nil {
+		return nil, err
+	}
+	return &MLE{corpus: corpus, order: order}, nil
+}
+
+func (lm *MLE) Vocabulary() *Vocabulary {
+	return lm.corpus.Vocabulary()
+}
+
+// Returns the tokenizer of the model's corpus, which Evaluate uses by default.
+func (lm *MLE) Tokenizer() Tokenizer {
+	return lm.corpus.Tokenizer()
+}
+
+func (lm *MLE) LogProb(context []int, word int) float64 {
+	context = lastTokens(context, lm.order-1)
+	total := lm.corpus.followedFrequency(context)
+	if word < 0 || word >= lm.corpus.voc.Size() || total == 0 {
+		return math.Inf(-1)
+	}
+	return math.Log2(float64(lm.corpus.Frequency(SeqJoin(context, []int{word}))) / float64(total))
+}
+
+func (lm *MLE) Score(seq []int) float64 {
+	return scoreSequence(lm, seq)
+}
+
+//
+// Additive smoothing.
+//
+
+// An ngram language model with additive smoothing, which adds k to the count of every ngram (Laplace smoothing if k is
+// 1). Words following unseen contexts are uniformly distributed.
+type AddK struct {
+	corpus *Corpus
+	order  int
+	k      float64
+}
+
+// Creates and returns a language model of ngrams of up to a given order in a corpus, smoothed by adding k > 0 to their
+// counts.
+func NewAddK(corpus *Corpus, order int, k float64) (lm *AddK, err error) {
+	if err = checkOrder(corpus, order); err != nil {
+		return nil, err
+	}
+	if !(k > 0.0) {
+		return nil, fmt.Errorf("corpustools: additive smoothing constant %g is not positive", k)
+	}
+	return &AddK{corpus: corpus, order: order, k: k}, nil
+}
+
+func (lm *AddK) Vocabulary() *Vocabulary {
+	return lm.corpus.Vocabulary()
+}
+
+// Returns the tokenizer of the model's corpus, which Evaluate uses by default.
+func (lm *AddK) Tokenizer() Tokenizer {
+	return lm.corpus.Tokenizer()
+}
+
+func (lm *AddK) LogProb(context []int, word int) float64 {
+	if word < 0 || word >= lm.corpus.voc.Size() {
+		return math.Inf(-1)
+	}
+	context = lastTokens(context, lm.order-1)
+	count := float64(lm.corpus.Frequency(SeqJoin(context, []int{word})))
+	total := float64(lm.corpus.followedFrequency(context))
+	return math.Log2((count + lm.k) / (total + lm.k*float64(lm.corpus.voc.Size())))
+}
+
+func (lm *AddK) Score(seq []int) float64 {
+	return scoreSequence(lm, seq)
+}
+
+//
+// Stupid backoff.
+//
+
+// The backoff factor recommended for stupid backoff by Brants et al. (2007).
+const DefaultStupidBackoffAlpha = 0.4
+
+// An ngram language model with stupid backoff (Brants et al., 2007), which uses the relative frequency of a word after
+// the longest context with which it occurs, multiplied by alpha for each token by which the context was shortened. Its
+// scores are cheap to compute but are not normalized, so they are not strictly probabilities, and a word which does not
+// occur in the corpus has a score of zero.
+type StupidBackoff struct {
+	corpus *Corpus
+	order  int
+	alpha  float64
+}
+
+// Creates and returns a stupid backoff language model of ngrams of up to a given order in a corpus, with a backoff factor
+// alpha in (0, 1].
+func NewStupidBackoff(corpus *Corpus, order int, alpha float64) (lm *StupidBackoff, err error) {
+	if err = checkOrder(corpus, order); err != nil {
+		return nil, err
+	}
+	if !(alpha > 0.0 && alpha <= 1.0) {
+		return nil, fmt.Errorf("corpustools: backoff factor %g is not in (0, 1]", alpha)
+	}
+	return &StupidBackoff{corpus: corpus, order: order, alpha: alpha}, nil
+}
+
+func (lm *StupidBackoff) Vocabulary() *Vocabulary {
+	return lm.corpus.Vocabulary()
+}
+
+// Returns the tokenizer of the model's corpus, which Evaluate uses by default.
+func (lm *StupidBackoff) Tokenizer() Tokenizer {
+	return lm.corpus.Tokenizer()
+}
+
+func (lm *StupidBackoff) LogProb(context []int, word int) float64 {
+	if word < 0 || word >= lm.corpus.voc.Size() {
+		return math.Inf(-1)
+	}
+	context = lastTokens(context, lm.order-1)
+	backoff := 0.0
+	for k := len(context); k >= 0; k-- {
+		count := lm.corpus.Frequency(SeqJoin(context[len(context)-k:], []int{word}))
+		if count > 0 {
+			return backoff + math.Log2(float64(count)/float64(lm.corpus.followedFrequency(context[len(context)-k:])))
+		}
+		backoff += math.Log2(lm.alpha)
+	}
+	return math.Inf(-1)
+}
+
+func (lm *StupidBackoff) Score(seq []int) float64 {
+	return scoreSequence(lm, seq)
+}
@@ -0,0 +1,92 @@
+package corpustools
+
+//
+// Longest common prefix (LCP) array.
+//
+
+// Computes the LCP array of the corpus, in which element spos is the length of the longest common prefix of the suffixes
+// at positions spos-1 and spos of the suffix array (and element 0 is 0). Together with the suffix array this forms an
+// enhanced suffix array, which speeds up ngram enumeration and allows repeats in the corpus to be found. The array is
+// computed in linear time (Kasai et al., 2001) and takes as much memory as the suffix array.
+func (corpus *Corpus) SetLCPArray() {
+	seq, sfx := intsOf(corpus.seq), intsOf(corpus.sfx)
+	n := len(seq)
+	// Find the position of each suffix in the suffix array.
+	rank := make([]int, n)
+	for spos, cpos := range sfx {
+		rank[cpos] = spos
+	}
+	// Visit suffixes in corpus order, using the fact that the common prefix shrinks by at most one each time.
+	lcp := make([]int, n)
+	h := 0
+	for cpos := 0; cpos < n; cpos++ {
+		if rank[cpos] == 0 {
+			h = 0
+			continue
+		}
+		prev := sfx[rank[cpos]-1]
+		for cpos+h < n && prev+h < n && seq[cpos+h] == seq[prev+h] {
+			h++
+		}
+		lcp[rank[cpos]] = h
+		if h > 0 {
+			h--
+		}
+	}
+	corpus.lcp = newIntArray(lcp, n)
+}
+
+// Returns whether the LCP array has been computed.
+func (corpus *Corpus) HasLCPArray() bool {
+	return corpus.lcp != nil
+}
+
+// Returns the length of the longest common prefix of the suffixes at positions spos-1 and spos of the suffix array (0 for
+// spos 0). The LCP array is used if it has been computed; otherwise the suffixes are compared.
+func (corpus *Corpus) LCP(spos int) int {
+	if corpus.lcp != nil {
+		return corpus.lcp.At(spos)
+	}
+	if spos == 0 {
+		return 0
+	}
+	return corpus.commonPrefix(corpus.sfx.At(spos-1), corpus.sfx.At(spos), corpus.seq.Len())
+}
+
+// Returns the length of the common prefix of the suffixes at two corpus positions, up to a limit.
+func (corpus *Corpus) commonPrefix(cpos1, cpos2, limit int) (length int) {
+	n := corpus.seq.Len()
+	for length < limit && cpos1+length < n && cpos2+length < n && corpus.seq.At(cpos1+length) == corpus.seq.At(cpos2+length) {
+		length++
+	}
+	return
+}
+
+//
+// Repeats.
+//
+
+// Returns the longest sequence which occurs more than once in the corpus (the first in suffix order if there are several),
+// or nil if no token occurs twice.
+func (corpus *Corpus) LongestRepeat() (seq []int) {
+	best, best_spos := 0, 0
+	for spos := 1; spos < corpus.sfx.Len(); spos++ {
+		if length := corpus.LCP(spos); length > best {
+			best, best_spos = length, spos
+		}
+	}
+	if best == 0 {
+		return nil
+	}
+	cpos := corpus.sfx.At(best_spos)
+	return sliceOf(corpus.seq, cpos, cpos+best)
+}
+
+// Returns the number of distinct non-empty substrings (sequences of any length) which occur in the corpus.
+func (corpus *Corpus) DistinctSubstrings() (count int) {
+	// Each suffix contributes its prefixes which are not shared with the preceding suffix.
+	for spos := 0; spos < corpus.sfx.Len(); spos++ {
+		count += corpus.seq.Len() - corpus.sfx.At(spos) - corpus.LCP(spos)
+	}
+	return
+}
@@ -166,5 +166,5 @@ func (mdlseg *MDLSegmenter) SegmentationStats(segmentation []string) (N int, U m
 
 // Returns an initialized MDLSegmenter based on the sequence contained in a corpus that is passed in.
 func NewMDLSegmenter(corpus *Corpus) MDLSegmenter {
-	return MDLSegmenter{sequence: corpus.seq, ngrams: NgramSet{ngrams: make(map[string][]int)}}
+	return MDLSegmenter{sequence: corpus.Corpus(), ngrams: NgramSet{ngrams: make(map[string][]int)}}
 }
@@ -0,0 +1,145 @@
+package corpustools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"unsafe"
+)
+
+// Opens a saved corpus index by memory-mapping it read-only, rather than loading it into memory. Searches run directly
+// over the mapped file, so an index larger than RAM can be used and several processes can share a single copy of it
+// through the operating system's page cache. The arrays are always checked to lie within the bounds of the corpus and
+// its vocabulary, which reads them once, so that a truncated or corrupted index cannot cause a panic in later searches.
+// If verify is set the checksums of the arrays are also checked; otherwise only that of the vocabulary is.
+//
+// The corpus must be closed with Close when it is no longer needed, after which it must not be used. Sequences returned
+// by its methods are copies, and remain valid.
+func MapCorpus(filename string, verify bool) (corpus *Corpus, err error) {
+	fh, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	data, err := mmapFile(fh)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, gzipMagic) || isBzip2(data) || bytes.HasPrefix(data, zstdMagic) {
+		munmap(data)
+		return nil, &IndexError{Reason: "a compressed index cannot be memory-mapped"}
+	}
+	ir := &indexReader{r: bytes.NewReader(data), size: int64(len(data)), crc: crc32.New(indexTable)}
+	corpus, err = ir.header()
+	if err == nil {
+		_, corpus.seq = ir.mappedIntArray(data, verify)
+		_, corpus.sfx = ir.mappedIntArray(data, verify)
+		for ir.more() {
+			corpus.setIndexArray(ir.mappedIntArray(data, verify))
+		}
+		err = ir.err
+	}
+	if err == nil {
+		corpus.setDefaultBoundaries()
+	}
+	if err == nil {
+		err = corpus.checkIndex()
+	}
+	if err != nil {
+		munmap(data)
+		return nil, err
+	}
+	corpus.mapping = data
+	return corpus, nil
+}
+
+// Releases the memory-mapped index behind a corpus opened with MapCorpus. It does nothing for other corpora.
+func (corpus *Corpus) Close() (err error) {
+	if corpus.mapping != nil {
+		err = munmap(corpus.mapping)
+		corpus.mapping, corpus.seq, corpus.sfx, corpus.lcp, corpus.docs, corpus.segs, corpus.attrs = nil, nil, nil, nil, nil, nil, nil
+	}
+	return
+}
+
+// Returns an array section of a mapped index, referring to the mapped data where possible.
+func (ir *indexReader) mappedIntArray(data []byte, verify bool) (kind uint32, arr intArray) {
+	width, kind, n := ir.arrayHeader()
+	if ir.err != nil {
+		return kind, nil
+	}
+	size := int64(arrayWords(width, int(n))) * 8
+	if size > int64(len(data))-ir.off {
+		ir.err = &IndexError{Reason: "unexpected end of index"}
+		return kind, nil
+	}
+	// Skip over the array words, including them in the checksum if it is to be verified.
+	region := data[ir.off : ir.off+size]
+	if verify {
+		ir.crc.Write(region)
+	}
+	ir.r.(io.Seeker).Seek(size, io.SeekCurrent)
+	ir.off += size
+	if verify {
+		ir.endSection()
+	} else {
+		ir.uint32()
+		for ir.off%8 != 0 && ir.err == nil {
+			ir.read(ir.buf[:1])
+		}
+	}
+	// Refer to the words in place if the platform's layout matches the index, or else decode them.
+	if len(region) == 0 || !isLittleEndian() || uintptr(unsafe.Pointer(&region[0]))%8 != 0 {
+		return kind, arrayFromWords(wordsFromBytes(region), width, int(n))
+	}
+	switch {
+	case width == 64 && strconv.IntSize == 64:
+		return kind, wideArray(unsafe.Slice((*int)(unsafe.Pointer(&region[0])), n))
+	case width == 32:
+		return kind, narrowArray(unsafe.Slice((*uint32)(unsafe.Pointer(&region[0])), n))
+	case width < 64:
+		words := unsafe.Slice((*uint64)(unsafe.Pointer(&region[0])), len(region)/8)
+		return kind, &packedArray{words: words, width: width, n: int(n), mask: (1 << width) - 1}
+	}
+	return kind, arrayFromWords(wordsFromBytes(region), width, int(n))
+}
+
+// Returns whether an array refers to the memory-mapped index of the corpus, and so cannot be changed.
+func (corpus *Corpus) isMapped(arr intArray) bool {
+	var p unsafe.Pointer
+	switch arr := arr.(type) {
+	case narrowArray:
+		if len(arr) > 0 {
+			p = unsafe.Pointer(&arr[0])
+		}
+	case wideArray:
+		if len(arr) > 0 {
+			p = unsafe.Pointer(&arr[0])
+		}
+	case *packedArray:
+		if len(arr.words) > 0 {
+			p = unsafe.Pointer(&arr.words[0])
+		}
+	}
+	if p == nil || len(corpus.mapping) == 0 {
+		return false
+	}
+	start := uintptr(unsafe.Pointer(&corpus.mapping[0]))
+	return uintptr(p) >= start && uintptr(p) < start+uintptr(len(corpus.mapping))
+}
+
+// Decodes little-endian 64-bit words.
+func wordsFromBytes(region []byte) (words []uint64) {
+	words = make([]uint64, len(region)/8)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(region[i*8:])
+	}
+	return
+}
+
+func isLittleEndian() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}
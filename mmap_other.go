@@ -0,0 +1,17 @@
+//go:build !unix
+
+package corpustools
+
+import (
+	"io"
+	"os"
+)
+
+// Reads a whole file into memory, on platforms without memory-mapping support.
+func mmapFile(fh *os.File) ([]byte, error) {
+	return io.ReadAll(fh)
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package corpustools
+
+import (
+	"os"
+	"syscall"
+)
+
+// Maps a whole file into memory read-only.
+func mmapFile(fh *os.File) ([]byte, error) {
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, &IndexError{Reason: "unexpected end of index"}
+	}
+	return syscall.Mmap(int(fh.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
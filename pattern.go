@@ -0,0 +1,304 @@
+package corpustools
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Patterns extend sequences with wildcards, gaps and alternatives. A pattern is a list of elements separated by
+// whitespace, each of which is one of:
+//
+//	token       the token itself
+//	(a|an|the)  any one of the listed tokens
+//	*           any single token
+//	{m,n}       between m and n tokens of any kind ({n} means exactly n)
+//
+// For example "the * of", "take {0,3} account" and "(a|an|the) house". A pattern must contain at least one token or set
+// of alternatives, so that it can be looked up in the suffix array. Tokens are looked up in the vocabulary as written,
+// without the corpus's tokenizer. Patterns are searched by looking up their most selective run of tokens in the suffix
+// array and then matching the rest of the pattern around each occurrence. Queries in the Corpus Query Language (see
+// cql.go) are searched in the same way.
+
+// Returned when a pattern cannot be parsed.
+type PatternError struct {
+	Pattern string
+	Reason  string
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("corpustools: invalid pattern %q: %s", e.Pattern, e.Reason)
+}
+
+// An occurrence of a pattern: the corpus positions [Position, Position+Length).
+type PatternMatch struct {
+	Position int
+	Length   int
+}
+
+// A distinct sequence matching a pattern, with the number of times it occurs.
+type PatternNgram struct {
+	Seq       []int
+	Frequency int
+}
+
+// An element of a parsed pattern or query: between min and max consecutive tokens (any number if max is negative), each
+// of which passes a test (or any token if the test is nil).
+type queryElement struct {
+	test     tokenTest
+	min, max int
+}
+
+// A test of the token at a corpus position.
+type tokenTest interface {
+	matches(cpos int) bool
+	// Returns the word types which a token passing the test must have, or false if the test does not restrict them.
+	wordTypes() (types []int, ok bool)
+}
+
+// Passes tokens with any of a list of word types.
+type typeTest struct {
+	seq   intArray
+	types []int
+}
+
+func (test typeTest) matches(cpos int) bool {
+	type_int := test.seq.At(cpos)
+	for _, allowed := range test.types {
+		if type_int == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (test typeTest) wordTypes() ([]int, bool) {
+	return test.types, true
+}
+
+//
+// Search methods.
+//
+
+// Returns the occurrences of a pattern which do not span a boundary of the given kind, in order of position and then
+// length. A pattern with gaps may match more than once at a position.
+func (corpus *Corpus) FindPattern(pattern string, within Boundary) (matches []PatternMatch, err error) {
+	elems, err := corpus.parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return corpus.findElements(elems, within), nil
+}
+
+// Returns the distinct sequences which match a pattern without spanning a boundary of the given kind, with their
+// frequencies, in descending order of frequency.
+func (corpus *Corpus) PatternNgrams(pattern string, within Boundary) (ngrams []PatternNgram, err error) {
+	matches, err := corpus.FindPattern(pattern, within)
+	if err != nil {
+		return nil, err
+	}
+	return corpus.matchNgrams(matches), nil
+}
+
+// Returns the distinct sequences at a list of matches, with their frequencies, in descending order of frequency.
+func (corpus *Corpus) matchNgrams(matches []PatternMatch) (ngrams []PatternNgram) {
+	index := make(map[string]int)
+	for _, match := range matches {
+		seq := sliceOf(corpus.seq, match.Position, match.Position+match.Length)
+		key := seqKey(seq)
+		if i, found := index[key]; found {
+			ngrams[i].Frequency++
+		} else {
+			index[key] = len(ngrams)
+			ngrams = append(ngrams, PatternNgram{Seq: seq, Frequency: 1})
+		}
+	}
+	sort.Slice(ngrams, func(i, j int) bool {
+		if ngrams[i].Frequency != ngrams[j].Frequency {
+			return ngrams[i].Frequency > ngrams[j].Frequency
+		}
+		return SeqCmp(ngrams[i].Seq, ngrams[j].Seq) == -1
+	})
+	return
+}
+
+//
+// Parsing.
+//
+
+// Parses a pattern into its elements. Alternatives which are not in the vocabulary are dropped, and an OOVError is
+// returned if a token, or every alternative in a set, is not in the vocabulary.
+func (corpus *Corpus) parsePattern(pattern string) (elems []queryElement, err error) {
+	var oov []string
+	for _, field := range strings.Fields(pattern) {
+		switch {
+		case field == "*":
+			elems = append(elems, queryElement{min: 1, max: 1})
+		case strings.HasPrefix(field, "{"):
+			bounds := strings.Split(strings.TrimSuffix(strings.TrimPrefix(field, "{"), "}"), ",")
+			if !strings.HasSuffix(field, "}") || len(bounds) > 2 {
+				return nil, &PatternError{Pattern: pattern, Reason: fmt.Sprintf("malformed gap %s", field)}
+			}
+			min, err1 := strconv.Atoi(bounds[0])
+			max, err2 := strconv.Atoi(bounds[len(bounds)-1])
+			if err1 != nil || err2 != nil || min < 0 || max < min {
+				return nil, &PatternError{Pattern: pattern, Reason: fmt.Sprintf("malformed gap %s", field)}
+			}
+			elems = append(elems, queryElement{min: min, max: max})
+		default:
+			alternatives := []string{field}
+			if strings.HasPrefix(field, "(") && strings.HasSuffix(field, ")") && len(field) > 2 {
+				alternatives = strings.Split(field[1:len(field)-1], "|")
+			}
+			test := typeTest{seq: corpus.seq, types: make([]int, 0, len(alternatives))}
+			for _, alternative := range alternatives {
+				if type_int, found := corpus.voc.ID(alternative); found {
+					test.types = append(test.types, type_int)
+				}
+			}
+			if len(test.types) == 0 {
+				oov = append(oov, field)
+			}
+			elems = append(elems, queryElement{test: test, min: 1, max: 1})
+		}
+	}
+	if oov != nil {
+		return nil, &OOVError{Tokens: oov}
+	}
+	if len(elems) == 0 {
+		return nil, &PatternError{Pattern: pattern, Reason: "empty pattern"}
+	}
+	// A pattern of wildcards and gaps alone would have to be matched at every position, in every combination of gaps.
+	for _, elem := range elems {
+		if elem.test != nil {
+			return elems, nil
+		}
+	}
+	return nil, &PatternError{Pattern: pattern, Reason: "pattern contains no tokens"}
+}
+
+//
+// Matching.
+//
+
+// Returns the occurrences of a list of elements which do not span a boundary of the given kind, in order of position and
+// then length.
+func (corpus *Corpus) findElements(elems []queryElement, within Boundary) (matches []PatternMatch) {
+	matches = make([]PatternMatch, 0)
+	for _, start := range corpus.elementStarts(elems) {
+		_, hi := corpus.contextRange(start, within)
+		// Elements of variable length can reach the same end in more than one way.
+		seen := make(map[int]bool)
+		for _, end := range corpus.matchElements(elems, start, hi) {
+			if !seen[end] {
+				seen[end] = true
+				matches = append(matches, PatternMatch{Position: start, Length: end - start})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Position != matches[j].Position {
+			return matches[i].Position < matches[j].Position
+		}
+		return matches[i].Length < matches[j].Length
+	})
+	return
+}
+
+// The maximum number of sequences a run of alternatives is expanded into when looking it up in the suffix array.
+const maxPatternExpansions = 1024
+
+// Returns whether an element matches exactly one token whose word type is restricted, so that it can be looked up in the
+// suffix array.
+func (elem queryElement) isAnchor() bool {
+	if elem.test == nil || elem.min != 1 || elem.max != 1 {
+		return false
+	}
+	_, ok := elem.test.wordTypes()
+	return ok
+}
+
+// Returns the candidate start positions of a list of elements, in no particular order. These are found from the
+// occurrences of the run of anchor elements with the fewest occurrences, among those at a bounded distance from the
+// start; if there is no such run the elements may start anywhere.
+func (corpus *Corpus) elementStarts(elems []queryElement) (starts []int) {
+	best_positions, best_min, best_max := []int(nil), 0, 0
+	found := false
+	min_off, max_off := 0, 0 // The range of distances from the start to the current element, with max_off -1 if unbounded.
+	for i := 0; i < len(elems); {
+		if !elems[i].isAnchor() {
+			min_off += elems[i].min
+			if elems[i].max < 0 || max_off < 0 {
+				max_off = -1
+			} else {
+				max_off += elems[i].max
+			}
+			i++
+			continue
+		}
+		// Expand the run of anchor elements starting here into sequences, stopping if there would be too many.
+		seqs := [][]int{{}}
+		j := i
+		for ; j < len(elems) && elems[j].isAnchor(); j++ {
+			types, _ := elems[j].test.wordTypes()
+			if j > i && len(seqs)*len(types) > maxPatternExpansions {
+				break
+			}
+			expanded := make([][]int, 0, len(seqs)*len(types))
+			for _, seq := range seqs {
+				for _, type_int := range types {
+					expanded = append(expanded, append(append([]int(nil), seq...), type_int))
+				}
+			}
+			seqs = expanded
+		}
+		if max_off >= 0 {
+			positions := make([]int, 0)
+			for _, seq := range seqs {
+				positions = append(positions, corpus.Find(seq)...)
+			}
+			if !found || len(positions) < len(best_positions) {
+				best_positions, best_min, best_max, found = positions, min_off, max_off, true
+			}
+			max_off += j - i
+		}
+		min_off += j - i
+		i = j
+	}
+	if !found {
+		starts = make([]int, corpus.seq.Len())
+		for cpos := range starts {
+			starts[cpos] = cpos
+		}
+		return
+	}
+	seen := make(map[int]bool)
+	for _, cpos := range best_positions {
+		for off := best_min; off <= best_max && off <= cpos; off++ {
+			if !seen[cpos-off] {
+				seen[cpos-off] = true
+				starts = append(starts, cpos-off)
+			}
+		}
+	}
+	return
+}
+
+// Returns the end positions of the matches of a list of elements starting at a corpus position and ending no later than
+// limit.
+func (corpus *Corpus) matchElements(elems []queryElement, cpos, limit int) (ends []int) {
+	if len(elems) == 0 {
+		return []int{cpos}
+	}
+	elem := elems[0]
+	for count := 0; elem.max < 0 || count <= elem.max; count++ {
+		if count >= elem.min {
+			ends = append(ends, corpus.matchElements(elems[1:], cpos+count, limit)...)
+		}
+		if cpos+count >= limit || (elem.test != nil && !elem.test.matches(cpos+count)) {
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package corpustools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Returned when a query contains tokens which are not in the corpus vocabulary.
+type OOVError struct {
+	Tokens []string // The out-of-vocabulary tokens, in the order they occur in the query.
+}
+
+func (e *OOVError) Error() string {
+	return fmt.Sprintf("corpustools: tokens not in vocabulary: %q", e.Tokens)
+}
+
+//
+// Tokenizer settings.
+//
+
+// Returns the tokenizer used to split text queries into tokens. This is the tokenizer the corpus was created with, or a
+// WhitespaceTokenizer if it was created from tokens or integers. Tokenizers are not saved in an index, so it is nil for a
+// corpus loaded or mapped from an index until SetTokenizer is called.
+func (corpus *Corpus) Tokenizer() Tokenizer {
+	return corpus.tokenizer
+}
+
+// Sets the tokenizer used to split text queries into tokens, e.g. after loading a saved index.
+func (corpus *Corpus) SetTokenizer(tokenizer Tokenizer) {
+	corpus.tokenizer = tokenizer
+}
+
+//
+// Conversion of queries to sequences.
+//
+
+// Converts text to a corpus sequence using the corpus's tokenizer, ignoring empty tokens. An OOVError is returned if any
+// token is not in the vocabulary, and an error if the corpus has no tokenizer.
+func (corpus *Corpus) Parse(text string) (seq []int, err error) {
+	tokenizer, err := corpus.queryTokenizer()
+	if err != nil {
+		return nil, err
+	}
+	return corpus.Lookup(tokenizer.Tokenize(text))
+}
+
+// Returns the corpus's tokenizer, or an error if it has none.
+func (corpus *Corpus) queryTokenizer() (Tokenizer, error) {
+	if corpus.tokenizer == nil {
+		return nil, fmt.Errorf("corpustools: the corpus has no tokenizer; call SetTokenizer after loading an index")
+	}
+	return corpus.tokenizer, nil
+}
+
+// Converts tokens to a corpus sequence, ignoring empty tokens. An OOVError is returned if any token is not in the
+// vocabulary. Text which has already been split into tokens is queried by passing the sequence to Find, Frequency and
+// the other methods which take sequences, rather than joining the tokens for FindOf and the like.
+func (corpus *Corpus) Lookup(tokens []string) (seq []int, err error) {
+	var oov []string
+	seq = make([]int, 0, len(tokens))
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		type_int, found := corpus.voc.ID(token)
+		if !found {
+			oov = append(oov, token)
+			continue
+		}
+		seq = append(seq, type_int)
+	}
+	if oov != nil {
+		return nil, &OOVError{Tokens: oov}
+	}
+	if len(seq) == 0 {
+		return nil, fmt.Errorf("corpustools: empty query %q", strings.Join(tokens, " "))
+	}
+	return seq, nil
+}
+
+//
+// Search, frequency and collocation methods for text queries. Tokens are queried with Lookup and the sequence methods.
+//
+
+// Returns the corpus indices where the text occurs.
+func (corpus *Corpus) FindOf(text string) (indices []int, err error) {
+	seq, err := corpus.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return corpus.Find(seq), nil
+}
+
+// Returns the number of times the text occurs in the corpus.
+func (corpus *Corpus) FrequencyOf(text string) (int, error) {
+	seq, err := corpus.Parse(text)
+	if err != nil {
+		return 0, err
+	}
+	return corpus.Frequency(seq), nil
+}
+
+// Returns the probability of the text in the corpus.
+func (corpus *Corpus) ProbabilityOf(text string) (float64, error) {
+	seq, err := corpus.Parse(text)
+	if err != nil {
+		return 0, err
+	}
+	return corpus.Probability(seq), nil
+}
+
+// Returns the mutual information, in bits, conveyed by the tokens in the text.
+func (corpus *Corpus) MutualInformationOf(text string) (float64, error) {
+	seq, err := corpus.Parse(text)
+	if err != nil {
+		return 0, err
+	}
+	return corpus.MutualInformation(seq), nil
+}
+
+// Returns a co-occurrence vector for the text.
+func (corpus *Corpus) CoocVectorOf(text string) (*Cooc, error) {
+	seq, err := corpus.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return corpus.CoocVector(seq), nil
+}
+
+// Returns the keyword-in-context lines for the occurrences of the text.
+func (corpus *Corpus) ConcordanceOf(text string, options ConcordanceOptions) ([]ConcordanceLine, error) {
+	seq, err := corpus.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return corpus.Concordance(seq, options), nil
+}
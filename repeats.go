@@ -0,0 +1,132 @@
+package corpustools
+
+import (
+	"sort"
+)
+
+// A sequence which occurs more than once in the corpus, with the corpus positions at which it occurs.
+type Repeat struct {
+	Seq       []int
+	Frequency int
+	Positions []int
+}
+
+// An interval of the suffix array whose suffixes share a common prefix of a given length (an lcp-interval), as visited in
+// a bottom-up traversal of the LCP array.
+type lcpInterval struct {
+	lcp       int  // Length of the common prefix.
+	lb        int  // Suffix array position at which the interval starts.
+	left      int  // The type preceding every suffix so far, or one of the leftNone and leftDiverse markers.
+	has_child bool // Whether the interval contains a smaller lcp-interval.
+}
+
+const (
+	leftNone    = -1
+	leftDiverse = -2
+)
+
+// Returns the maximal repeats in the corpus which are at least min_length tokens long and occur at least min_frequency
+// times, in order of their sequences. A repeat is maximal if it cannot be extended to the left or the right without
+// losing an occurrence. If supermaximal is set only the supermaximal repeats are returned: those which do not occur
+// within any other maximal repeat.
+//
+// Repeats are found in a single pass over the suffix array and LCP array, so SetLCPArray should be called first on
+// large corpora (otherwise common prefixes are computed as required).
+func (corpus *Corpus) Repeats(min_length, min_frequency int, supermaximal bool) (repeats []Repeat) {
+	if min_length < 1 {
+		min_length = 1
+	}
+	if min_frequency < 2 {
+		min_frequency = 2
+	}
+	n := corpus.sfx.Len()
+	// Reports an lcp-interval ending at suffix array position rb if it is a repeat of the required kind.
+	report := func(interval lcpInterval, rb int) {
+		frequency := rb - interval.lb + 1
+		if interval.lcp < min_length || frequency < min_frequency || interval.left != leftDiverse {
+			return
+		}
+		if supermaximal && (interval.has_child || !corpus.distinctLeftTypes(interval.lb, rb)) {
+			return
+		}
+		repeat := Repeat{Frequency: frequency, Positions: make([]int, frequency)}
+		for spos := interval.lb; spos <= rb; spos++ {
+			repeat.Positions[spos-interval.lb] = corpus.sfx.At(spos)
+		}
+		sort.Ints(repeat.Positions)
+		repeat.Seq = sliceOf(corpus.seq, repeat.Positions[0], repeat.Positions[0]+interval.lcp)
+		repeats = append(repeats, repeat)
+	}
+	// Traverse the lcp-intervals bottom up, keeping the intervals enclosing the current suffix on a stack.
+	stack := []lcpInterval{{lcp: 0, lb: 0, left: leftNone}}
+	for spos := 1; spos <= n; spos++ {
+		lcp := 0
+		if spos < n {
+			lcp = corpus.LCP(spos)
+		}
+		// The suffix before spos belongs to the deepest interval enclosing it.
+		left := corpus.leftType(spos - 1)
+		if lcp > stack[len(stack)-1].lcp {
+			stack = append(stack, lcpInterval{lcp: lcp, lb: spos - 1, left: left})
+			continue
+		}
+		top := &stack[len(stack)-1]
+		top.left = mergeLeft(top.left, left)
+		// Close the intervals which end at the suffix before spos, passing their details up to their parents.
+		lb := spos - 1
+		var last *lcpInterval
+		for lcp < stack[len(stack)-1].lcp {
+			closed := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			report(closed, spos-1)
+			lb = closed.lb
+			last = &closed
+			if lcp <= stack[len(stack)-1].lcp {
+				parent := &stack[len(stack)-1]
+				parent.left = mergeLeft(parent.left, closed.left)
+				parent.has_child = true
+			}
+		}
+		if last != nil && lcp > stack[len(stack)-1].lcp {
+			stack = append(stack, lcpInterval{lcp: lcp, lb: lb, left: last.left, has_child: true})
+		}
+	}
+	sort.Slice(repeats, func(i, j int) bool {
+		return SeqCmp(repeats[i].Seq, repeats[j].Seq) == -1
+	})
+	return
+}
+
+// Returns the type preceding the suffix at a suffix array position, or leftDiverse for the suffix at the start of the
+// corpus, which no extension to the left can match.
+func (corpus *Corpus) leftType(spos int) int {
+	cpos := corpus.sfx.At(spos)
+	if cpos == 0 {
+		return leftDiverse
+	}
+	return corpus.seq.At(cpos - 1)
+}
+
+// Returns whether the suffixes in a range of the suffix array are all preceded by different types.
+func (corpus *Corpus) distinctLeftTypes(slo, shi int) bool {
+	seen := make(map[int]bool, shi-slo+1)
+	for spos := slo; spos <= shi; spos++ {
+		left := corpus.leftType(spos)
+		if left != leftDiverse && seen[left] {
+			return false
+		}
+		seen[left] = true
+	}
+	return true
+}
+
+// Combines the preceding types of two sets of suffixes.
+func mergeLeft(left1, left2 int) int {
+	switch {
+	case left1 == leftNone:
+		return left2
+	case left2 == leftNone || left1 == left2:
+		return left1
+	}
+	return leftDiverse
+}
@@ -0,0 +1,159 @@
+package corpustools
+
+// Suffix array construction by induced sorting (SA-IS; Nong, Zhang and Chan, 2009), which runs in time linear in the
+// length of the corpus over an integer alphabet, whatever its content. A virtual sentinel smaller than every type is
+// assumed to follow the text, so that a suffix which is a prefix of another sorts first, as in SeqCmp.
+
+// Computes the suffix array of text, whose elements must lie in [0, k), into sa (which must have the same length).
+func sais(text []int, sa []int, k int) {
+	n := len(text)
+	if n == 0 {
+		return
+	}
+	if n == 1 {
+		sa[0] = 0
+		return
+	}
+	// Classify each suffix as S-type (smaller than the suffix after it) or L-type (larger). The last suffix is L-type as
+	// it is larger than the sentinel.
+	stype := make([]bool, n)
+	for i := n - 2; i >= 0; i-- {
+		stype[i] = text[i] < text[i+1] || (text[i] == text[i+1] && stype[i+1])
+	}
+	isLMS := func(i int) bool {
+		return i > 0 && i < n && stype[i] && !stype[i-1]
+	}
+	bkt := make([]int, k)
+	// Place the leftmost S-type (LMS) suffixes at the ends of their buckets and induce an ordering of LMS substrings.
+	for i := range sa {
+		sa[i] = -1
+	}
+	bucketEnds(text, bkt)
+	for i := n - 1; i > 0; i-- {
+		if isLMS(i) {
+			bkt[text[i]]--
+			sa[bkt[text[i]]] = i
+		}
+	}
+	induceSort(text, sa, stype, bkt)
+	// Move the sorted LMS suffixes to the front of sa.
+	m := 0
+	for i := 0; i < n; i++ {
+		if isLMS(sa[i]) {
+			sa[m] = sa[i]
+			m++
+		}
+	}
+	for i := m; i < n; i++ {
+		sa[i] = -1
+	}
+	// Name each LMS substring by its rank, storing the name of the substring at pos in sa[m+pos/2].
+	names, prev := 0, -1
+	for i := 0; i < m; i++ {
+		pos := sa[i]
+		diff := prev == -1
+		for d := 0; !diff; d++ {
+			if pos+d == n || prev+d == n || text[pos+d] != text[prev+d] || stype[pos+d] != stype[prev+d] {
+				diff = true
+			} else if d > 0 && isLMS(pos+d) {
+				break
+			}
+		}
+		if diff {
+			names++
+			prev = pos
+		}
+		sa[m+pos/2] = names - 1
+	}
+	// Gather the names in text order into the reduced string at the end of sa.
+	j := n - 1
+	for i := n - 1; i >= m; i-- {
+		if sa[i] >= 0 {
+			sa[j] = sa[i]
+			j--
+		}
+	}
+	reduced, sa1 := sa[n-m:], sa[:m]
+	// Sort the suffixes of the reduced string, recursively if any names are repeated.
+	if names < m {
+		sais(reduced, sa1, names)
+	} else {
+		for i := 0; i < m; i++ {
+			sa1[reduced[i]] = i
+		}
+	}
+	// Map the sorted reduced suffixes back to the positions of the LMS suffixes in the text.
+	j = 0
+	for i := 1; i < n; i++ {
+		if isLMS(i) {
+			reduced[j] = i
+			j++
+		}
+	}
+	for i := 0; i < m; i++ {
+		sa1[i] = reduced[sa1[i]]
+	}
+	for i := m; i < n; i++ {
+		sa[i] = -1
+	}
+	// Place the sorted LMS suffixes at the ends of their buckets and induce the order of all suffixes from them.
+	bucketEnds(text, bkt)
+	for i := m - 1; i >= 0; i-- {
+		pos := sa[i]
+		sa[i] = -1
+		bkt[text[pos]]--
+		sa[bkt[text[pos]]] = pos
+	}
+	induceSort(text, sa, stype, bkt)
+}
+
+// Induces the order of the L-type suffixes from the sorted S-type suffixes in sa, and then of the S-type suffixes from
+// the L-type suffixes.
+func induceSort(text []int, sa []int, stype []bool, bkt []int) {
+	n := len(text)
+	// Scan forwards, placing L-type suffixes at the starts of their buckets. The last suffix follows the sentinel.
+	bucketStarts(text, bkt)
+	sa[bkt[text[n-1]]] = n - 1
+	bkt[text[n-1]]++
+	for i := 0; i < n; i++ {
+		if j := sa[i] - 1; j >= 0 && !stype[j] {
+			sa[bkt[text[j]]] = j
+			bkt[text[j]]++
+		}
+	}
+	// Scan backwards, placing S-type suffixes at the ends of their buckets.
+	bucketEnds(text, bkt)
+	for i := n - 1; i >= 0; i-- {
+		if j := sa[i] - 1; j >= 0 && stype[j] {
+			bkt[text[j]]--
+			sa[bkt[text[j]]] = j
+		}
+	}
+}
+
+// Sets bkt[c] to the index in the suffix array at which the bucket of suffixes starting with type c begins.
+func bucketStarts(text []int, bkt []int) {
+	bucketEnds(text, bkt)
+	for c := len(bkt) - 1; c >= 0; c-- {
+		if c > 0 {
+			bkt[c] = bkt[c-1]
+		} else {
+			bkt[c] = 0
+		}
+	}
+}
+
+// Sets bkt[c] to the index in the suffix array just past the end of the bucket of suffixes starting with type c.
+func bucketEnds(text []int, bkt []int) {
+	for c := range bkt {
+		bkt[c] = 0
+	}
+	for _, c := range text {
+		bkt[c]++
+	}
+	sum := 0
+	for c := range bkt {
+		sum += bkt[c]
+		bkt[c] = sum
+	}
+}
@@ -0,0 +1,318 @@
+package corpustools
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Tokenizer splits a line of text into string tokens. Corpus constructors accept any Tokenizer.
+type Tokenizer interface {
+	Tokenize(line string) []string
+}
+
+//
+// Tokenizers.
+//
+
+// Tokenizes lines in the original manner of TokenizeLine: words split on regular expression word boundaries, or characters
+// with anything outside [a-z0-9 ,;:.] mapped to "XXX".
+type ClassicTokenizer struct {
+	LowerCase   bool
+	ReturnChars bool
+}
+
+func (tokenizer ClassicTokenizer) Tokenize(line string) []string {
+	return TokenizeLine(line, tokenizer.LowerCase, tokenizer.ReturnChars)
+}
+
+// Splits lines into words on Unicode whitespace.
+type WhitespaceTokenizer struct{}
+
+func (tokenizer WhitespaceTokenizer) Tokenize(line string) []string {
+	return strings.Fields(line)
+}
+
+// Splits lines into the individual bytes of their UTF-8 encoding.
+type ByteTokenizer struct{}
+
+func (tokenizer ByteTokenizer) Tokenize(line string) (tokens []string) {
+	tokens = make([]string, len(line))
+	for i := 0; i < len(line); i++ {
+		tokens[i] = line[i : i+1]
+	}
+	return
+}
+
+// Splits lines into Unicode characters. If Graphemes is set each token is an extended grapheme cluster (a base character
+// together with any combining marks, joiners and modifiers that follow it, as in UAX #29), otherwise each token is a
+// single code point.
+type CharTokenizer struct {
+	Graphemes bool
+}
+
+func (tokenizer CharTokenizer) Tokenize(line string) (tokens []string) {
+	tokens = make([]string, 0, len(line))
+	for pos := 0; pos < len(line); {
+		size := 0
+		if tokenizer.Graphemes {
+			size = graphemeLength(line[pos:])
+		} else {
+			_, size = utf8.DecodeRuneInString(line[pos:])
+		}
+		tokens = append(tokens, line[pos:pos+size])
+		pos += size
+	}
+	return
+}
+
+// Splits lines into tokens with a regular expression. If Gaps is false the tokens are the matches of the expression,
+// otherwise the expression matches the separators between tokens (and empty tokens are discarded).
+type RegexpTokenizer struct {
+	Regexp *regexp.Regexp
+	Gaps   bool
+}
+
+// Returns a RegexpTokenizer for a regular expression, or an error if the expression cannot be compiled.
+func NewRegexpTokenizer(expr string, gaps bool) (*RegexpTokenizer, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &RegexpTokenizer{Regexp: re, Gaps: gaps}, nil
+}
+
+func (tokenizer *RegexpTokenizer) Tokenize(line string) (tokens []string) {
+	if !tokenizer.Gaps {
+		return tokenizer.Regexp.FindAllString(line, -1)
+	}
+	for _, token := range tokenizer.Regexp.Split(line, -1) {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return
+}
+
+// Splits lines into words following the default word boundary rules of UAX #29 (Unicode Text Segmentation). Runs of
+// letters and digits form words, including internal apostrophes, periods and colons between letters ("can't", "e.g")
+// and commas and periods between digits ("3,000.5"). Ideographs and punctuation form one token per character, and
+// whitespace is discarded. The emoji and regional indicator rules of the standard are simplified to keeping joined
+// sequences together.
+type WordTokenizer struct{}
+
+func (tokenizer WordTokenizer) Tokenize(line string) (tokens []string) {
+	// Decode the runes with their byte offsets, so tokens can be sliced from the line. An invalid byte decodes as
+	// utf8.RuneError with a width of one byte.
+	runes, offsets := make([]rune, 0, len(line)), make([]int, 0, len(line)+1)
+	for pos := 0; pos < len(line); {
+		rn, width := utf8.DecodeRuneInString(line[pos:])
+		runes, offsets = append(runes, rn), append(offsets, pos)
+		pos += width
+	}
+	offsets = append(offsets, len(line))
+	// Scan the line for words.
+	for i := 0; i < len(runes); {
+		start := i
+		class := wordClass(runes[i])
+		i = skipExtenders(runes, i+1)
+		switch class {
+		case wbSpace:
+			continue
+		case wbALetter, wbNumeric, wbExtendNumLet:
+			i = wordEnd(runes, i)
+		case wbKatakana:
+			for i < len(runes) && (wordClass(runes[i]) == wbKatakana || wordClass(runes[i]) == wbExtendNumLet) {
+				i = skipExtenders(runes, i+1)
+			}
+		}
+		tokens = append(tokens, line[offsets[start]:offsets[i]])
+	}
+	return
+}
+
+//
+// Lower casing.
+//
+
+type lowerCaseTokenizer struct {
+	tokenizer Tokenizer
+}
+
+// Returns a Tokenizer which lower cases lines before passing them to another Tokenizer.
+func LowerCase(tokenizer Tokenizer) Tokenizer {
+	return lowerCaseTokenizer{tokenizer: tokenizer}
+}
+
+func (tokenizer lowerCaseTokenizer) Tokenize(line string) []string {
+	return tokenizer.tokenizer.Tokenize(strings.ToLower(line))
+}
+
+//
+// Word boundary classes used by WordTokenizer.
+//
+
+const (
+	wbOther = iota
+	wbSpace
+	wbALetter
+	wbNumeric
+	wbKatakana
+	wbIdeographic
+	wbExtendNumLet
+	wbMidLetter
+	wbMidNum
+	wbMidNumLet
+)
+
+func wordClass(rn rune) int {
+	switch {
+	case unicode.IsSpace(rn):
+		return wbSpace
+	case unicode.Is(unicode.Katakana, rn) || rn == 0x30FC:
+		return wbKatakana
+	case unicode.Is(unicode.Han, rn) || unicode.Is(unicode.Hiragana, rn):
+		return wbIdeographic
+	case unicode.IsLetter(rn):
+		return wbALetter
+	case unicode.Is(unicode.Nd, rn):
+		return wbNumeric
+	case unicode.Is(unicode.Pc, rn):
+		return wbExtendNumLet
+	}
+	switch rn {
+	case ':', 0x00B7, 0x0387, 0x05F4, 0x2027, 0xFE13, 0xFE55, 0xFF1A:
+		return wbMidLetter
+	case ',', ';', 0x037E, 0x0589, 0x060C, 0x060D, 0x066C, 0x07F8, 0x2044, 0xFE10, 0xFE14, 0xFE50, 0xFE54, 0xFF0C, 0xFF1B:
+		return wbMidNum
+	case '.', '\'', 0x2018, 0x2019, 0x2024, 0xFE52, 0xFF07, 0xFF0E:
+		return wbMidNumLet
+	}
+	return wbOther
+}
+
+// Returns whether a rune attaches to the rune before it (combining marks, format characters and joiners).
+func isExtender(rn rune) bool {
+	return unicode.In(rn, unicode.Mn, unicode.Me, unicode.Mc, unicode.Cf) || unicode.Is(unicode.Variation_Selector, rn) || (rn >= 0x1F3FB && rn <= 0x1F3FF)
+}
+
+// Returns the index of the first rune at or after i which does not extend the preceding rune. A zero width joiner also
+// joins the rune which follows it.
+func skipExtenders(runes []rune, i int) int {
+	for i < len(runes) && isExtender(runes[i]) {
+		if runes[i] == 0x200D && i+1 < len(runes) {
+			i++
+		}
+		i++
+	}
+	return i
+}
+
+// Returns the index just past the end of the word containing the letters, digits and connectors before i.
+func wordEnd(runes []rune, i int) int {
+	prev := wordClass(runes[i-1])
+	for i < len(runes) {
+		class := wordClass(runes[i])
+		if class == wbALetter || class == wbNumeric || class == wbExtendNumLet {
+			prev = class
+			i = skipExtenders(runes, i+1)
+			continue
+		}
+		// A medial character only continues the word if it is surrounded by letters or digits as appropriate.
+		next := skipExtenders(runes, i+1)
+		if next >= len(runes) {
+			break
+		}
+		following := wordClass(runes[next])
+		letters := prev == wbALetter && following == wbALetter && (class == wbMidLetter || class == wbMidNumLet)
+		digits := prev == wbNumeric && following == wbNumeric && (class == wbMidNum || class == wbMidNumLet)
+		if !letters && !digits {
+			break
+		}
+		i = next
+	}
+	return i
+}
+
+// Returns the length in bytes of the extended grapheme cluster at the start of a string.
+func graphemeLength(str string) int {
+	rn, size := utf8.DecodeRuneInString(str)
+	// A carriage return and line feed form a single cluster; other controls stand alone.
+	if rn == '\r' && len(str) > 1 && str[1] == '\n' {
+		return 2
+	}
+	if unicode.IsControl(rn) {
+		return size
+	}
+	regional := isRegionalIndicator(rn)
+	hangul := hangulKind(rn)
+	for size < len(str) {
+		next, next_size := utf8.DecodeRuneInString(str[size:])
+		switch {
+		case isExtender(next) && !unicode.IsControl(next):
+			// Combining marks, joiners and modifiers extend the cluster, and a joiner also takes the rune after it.
+			size += next_size
+			if next == 0x200D && size < len(str) {
+				_, joined_size := utf8.DecodeRuneInString(str[size:])
+				size += joined_size
+			}
+			continue
+		case regional && isRegionalIndicator(next):
+			// Regional indicators pair up into flags.
+			regional = false
+			size += next_size
+			continue
+		case hangul != 0 && hangulFollows(hangul, hangulKind(next)):
+			// Hangul jamo combine into syllables.
+			hangul = hangulKind(next)
+			size += next_size
+			continue
+		}
+		break
+	}
+	return size
+}
+
+func isRegionalIndicator(rn rune) bool {
+	return rn >= 0x1F1E6 && rn <= 0x1F1FF
+}
+
+// Hangul syllable types: leading consonant (L), vowel (V), trailing consonant (T), and precomposed LV and LVT syllables.
+const (
+	hangulL = iota + 1
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+func hangulKind(rn rune) int {
+	switch {
+	case (rn >= 0x1100 && rn <= 0x115F) || (rn >= 0xA960 && rn <= 0xA97C):
+		return hangulL
+	case (rn >= 0x1160 && rn <= 0x11A7) || (rn >= 0xD7B0 && rn <= 0xD7C6):
+		return hangulV
+	case (rn >= 0x11A8 && rn <= 0x11FF) || (rn >= 0xD7CB && rn <= 0xD7FB):
+		return hangulT
+	case rn >= 0xAC00 && rn <= 0xD7A3:
+		if (rn-0xAC00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return 0
+}
+
+// Returns whether a Hangul jamo of one kind can be followed by another within a syllable.
+func hangulFollows(prev, next int) bool {
+	switch prev {
+	case hangulL:
+		return next == hangulL || next == hangulV || next == hangulLV || next == hangulLVT
+	case hangulV, hangulLV:
+		return next == hangulV || next == hangulT
+	case hangulT, hangulLVT:
+		return next == hangulT
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package corpustools
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -10,41 +11,153 @@ import (
 	"unicode"
 )
 
-// Streams the tokens within a text file.
+//
+// Errors returned when reading input.
+//
+
+// Returned when an input file does not exist.
+type FileNotFoundError struct {
+	Filename string
+	Err      error
+}
+
+func (e *FileNotFoundError) Error() string {
+	return fmt.Sprintf("corpustools: file not found: %s", e.Filename)
+}
+
+func (e *FileNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when the input cannot be read or decoded. Line is the line being read when the error occurred.
+type DecodeError struct {
+	Filename string
+	Line     int
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("corpustools: %s:%d: %v", e.Filename, e.Line, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when a line of input is longer than the MaxLineLength of a CorpusReader.
+type LineTooLongError struct {
+	Filename string
+	Line     int
+	Max      int
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("corpustools: %s:%d: line longer than %d bytes", e.Filename, e.Line, e.Max)
+}
+
+//
+// Functions to read tokens.
+//
+
+// Streams the tokens within a text file, exiting the program if the file cannot be read.
 func TokensFromFile(filename string, lowerCase bool, returnChars bool) (tokens []string) {
-	var (
-		bfr *bufio.Reader
-		tks []string
-	)
+	tokens, err := ReadTokensFromFile(filename, ClassicTokenizer{LowerCase: lowerCase, ReturnChars: returnChars})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return
+}
+
+// Returns the tokens within a text file, splitting each line into tokens with a tokenizer.
+func ReadTokensFromFile(filename string, tokenizer Tokenizer) (tokens []string, err error) {
+	return CorpusReader{Tokenizer: tokenizer}.ReadTokensFromFile(filename)
+}
+
+// Returns the tokens within the text read from r, splitting each line into tokens with a tokenizer.
+func ReadTokens(r io.Reader, tokenizer Tokenizer) (tokens []string, err error) {
+	return CorpusReader{Tokenizer: tokenizer}.ReadTokens(r)
+}
+
+// Returns the tokens within a text file, splitting each line into tokens with the reader's tokenizer.
+func (reader CorpusReader) ReadTokensFromFile(filename string) (tokens []string, err error) {
+	tokens = make([]string, 0)
+	err = eachLineInFile(filename, reader.MaxLineLength, func(line string) error {
+		tokens = append(tokens, reader.Tokenizer.Tokenize(line)...)
+		return nil
+	})
+	return
+}
+
+// Returns the tokens within the text read from r, which is decompressed if necessary, splitting each line into tokens
+// with the reader's tokenizer.
+func (reader CorpusReader) ReadTokens(r io.Reader) (tokens []string, err error) {
+	if r, err = decompress(r, "<reader>"); err != nil {
+		return nil, err
+	}
 	tokens = make([]string, 0)
-	// Open the file for reading.
+	err = eachLine(r, "<reader>", reader.MaxLineLength, func(line string) error {
+		tokens = append(tokens, reader.Tokenizer.Tokenize(line)...)
+		return nil
+	})
+	return
+}
+
+// Opens a file for reading, reporting a missing file as a FileNotFoundError.
+func openFile(filename string) (*os.File, error) {
 	fh, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, &FileNotFoundError{Filename: filename, Err: err}
+	}
+	return fh, err
+}
+
+// Calls fn with each line of a file in turn, allowing lines of up to max_length bytes (any length if it is 0) and stopping
+// at the first error fn returns.
+func eachLineInFile(filename string, max_length int, fn func(line string) error) error {
+	fh, err := openInput(filename)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer fh.Close()
-	// Read the lines of the file one at a time.
-	bfr = bufio.NewReaderSize(fh, 1024*16)
-	for line, isprefix, err := bfr.ReadLine(); err != io.EOF; {
-		// Error handling.
-		if err != nil {
-			log.Fatal(err)
-		}
-		if isprefix {
-			log.Fatal("Line too long for buffered reader.")
+	return eachLine(fh, filename, max_length, fn)
+}
+
+// Calls fn with each line read from r in turn, with line endings removed, stopping at the first error fn returns. The
+// name is used to report errors, and lines longer than max_length bytes are reported with a LineTooLongError unless it
+// is 0.
+func eachLine(r io.Reader, name string, max_length int, fn func(line string) error) error {
+	bfr := bufio.NewReaderSize(r, 1024*16)
+	buf := make([]byte, 0, 1024)
+	for lineno := 1; ; lineno++ {
+		// Read a whole line, which may take several reads if it is longer than the buffer.
+		buf = buf[:0]
+		for {
+			part, isprefix, err := bfr.ReadLine()
+			if err == io.EOF {
+				// A final line that exactly filled the buffer has no terminator to end it.
+				if len(buf) > 0 {
+					return fn(string(buf))
+				}
+				return nil
+			}
+			if err != nil {
+				return &DecodeError{Filename: name, Line: lineno, Err: err}
+			}
+			buf = append(buf, part...)
+			if max_length > 0 && len(buf) > max_length {
+				return &LineTooLongError{Filename: name, Line: lineno, Max: max_length}
+			}
+			if !isprefix {
+				break
+			}
 		}
-		// Convert the bytes in the line to nice tokens.
-		tks = TokenizeLine(string(line), lowerCase, returnChars)
-		for _, tk := range tks {
-			tokens = append(tokens, tk)
+		if err := fn(string(buf)); err != nil {
+			return err
 		}
-		// Read from the file for the next iteration.
-		line, isprefix, err = bfr.ReadLine()
 	}
-	return
 }
 
-// Converts a string (e.g. a line from a file) into an array of tokens.
+// Converts a string (e.g. a line from a file) into an array of tokens. See also ClassicTokenizer and the other Tokenizers.
 func TokenizeLine(line string, lowerCase bool, returnChars bool) (tokens []string) {
 	// Lower case everything if required.
 	if lowerCase {
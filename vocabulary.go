@@ -0,0 +1,115 @@
+package corpustools
+
+import (
+	"fmt"
+)
+
+// A Vocabulary is a bidirectional mapping between string tokens (types) and the integers which represent them in a corpus.
+// The integers are assigned consecutively from 0, so lookups in both directions take constant time. The vocabularies of
+// corpora and language models are read-only; NewVocabularyFromStrings(voc.Strings()) returns a copy which can be added to.
+type Vocabulary struct {
+	ids      map[string]int
+	strs     []string
+	readOnly bool
+}
+
+// Returns an empty vocabulary.
+func NewVocabulary() *Vocabulary {
+	return &Vocabulary{ids: make(map[string]int), strs: make([]string, 0)}
+}
+
+// Returns a vocabulary containing the given strings, each represented by its index in the slice.
+func NewVocabularyFromStrings(strs []string) (voc *Vocabulary, err error) {
+	voc = &Vocabulary{ids: make(map[string]int, len(strs)), strs: make([]string, len(strs))}
+	for id, str := range strs {
+		if _, found := voc.ids[str]; found {
+			return nil, fmt.Errorf("corpustools: %q occurs more than once in the vocabulary", str)
+		}
+		voc.ids[str] = id
+		voc.strs[id] = str
+	}
+	return
+}
+
+// Returns a vocabulary containing the mapping from strings to integers in a map. The map must assign its strings the
+// distinct integers 0 to len(ids)-1.
+func NewVocabularyFromMap(ids map[string]int) (voc *Vocabulary, err error) {
+	voc = &Vocabulary{ids: make(map[string]int, len(ids)), strs: make([]string, len(ids))}
+	seen := make([]bool, len(ids))
+	for str, id := range ids {
+		if id < 0 || id >= len(ids) || seen[id] {
+			return nil, fmt.Errorf("corpustools: vocabulary maps %q to %d; integers must be distinct and in [0, %d)", str, id, len(ids))
+		}
+		seen[id] = true
+		voc.ids[str] = id
+		voc.strs[id] = str
+	}
+	return
+}
+
+// Returns the integer representing a string, and whether the string is in the vocabulary.
+func (voc *Vocabulary) ID(str string) (id int, found bool) {
+	id, found = voc.ids[str]
+	return
+}
+
+// Returns the string represented by an integer, or the empty string if the integer is not in the vocabulary.
+func (voc *Vocabulary) String(id int) string {
+	if id < 0 || id >= len(voc.strs) {
+		return ""
+	}
+	return voc.strs[id]
+}
+
+// Returns the number of strings in the vocabulary.
+func (voc *Vocabulary) Size() int {
+	return len(voc.strs)
+}
+
+// Returns the integer representing a string, adding the string to the vocabulary if it is not already present. An error
+// is returned if the string is not present and the vocabulary is read-only.
+func (voc *Vocabulary) Add(str string) (id int, err error) {
+	if id, found := voc.ids[str]; found {
+		return id, nil
+	}
+	if voc.readOnly {
+		return -1, fmt.Errorf("corpustools: cannot add %q to a read-only vocabulary", str)
+	}
+	return voc.add(str), nil
+}
+
+// Returns the integer representing a string, adding the string to the vocabulary (read-only or not) if it is not already
+// present.
+func (voc *Vocabulary) add(str string) (id int) {
+	id, found := voc.ids[str]
+	if !found {
+		id = len(voc.strs)
+		voc.ids[str] = id
+		voc.strs = append(voc.strs, str)
+	}
+	return
+}
+
+// Returns whether the vocabulary is read-only.
+func (voc *Vocabulary) ReadOnly() bool {
+	return voc.readOnly
+}
+
+// Returns a read-only view of the vocabulary, which shares its mapping.
+func (voc *Vocabulary) view() *Vocabulary {
+	return &Vocabulary{ids: voc.ids, strs: voc.strs, readOnly: true}
+}
+
+// Returns a copy of the strings in the vocabulary, in the order of the integers representing them.
+func (voc *Vocabulary) Strings() []string {
+	return append([]string(nil), voc.strs...)
+}
+
+// Calls fn with each integer and string in the vocabulary in integer order, stopping early if fn returns false.
+func (voc *Vocabulary) Each(fn func(id int, str string) bool) {
+	for id, str := range voc.strs {
+		if !fn(id, str) {
+			return
+		}
+	}
+}